@@ -0,0 +1,257 @@
+// Package adfslogin obtains AWS credentials through an ADFS portal for Go programs,
+// using the same SAML login and STS exchange as the aws-login command without
+// prompting or touching the AWS credentials file.
+//
+// The SAML assertion is only verified when Options.ValidateAssertion is set, which
+// is off by default. Without it the roles are trusted as returned by the portal.
+package adfslogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/auth"
+	"github.com/S7R4nG3/aws-adfs-login/logging"
+	"github.com/S7R4nG3/aws-adfs-login/saml"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// The session duration requested when Options.Duration is zero
+	DefaultDuration = 15 * time.Minute
+	// The Source of the credentials returned by the client
+	CredentialsSource = "adfslogin"
+)
+
+// An AWS IAM role granted by the SAML assertion.
+type Role = types.Role
+
+// The ADFS login credentials.
+type User = types.User
+
+// The kinds of failure returned by the client, test for them with errors.Is.
+var (
+	ErrNetwork    = types.ErrNetwork
+	ErrAuthFailed = types.ErrAuthFailed
+	ErrNoRoles    = types.ErrNoRoles
+	ErrStsDenied  = types.ErrStsDenied
+)
+
+// The settings of a Client, only IdpEntryUrl, User and Region, or a StsClient
+// instead of the Region, are required.
+type Options struct {
+	// The ADFS sign-in URL, for example https://adfs.example.com/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices
+	IdpEntryUrl string
+	// The user to sign in as, the client never prompts so the password is required
+	User User
+	// How the username and domain are combined, one of saml.UsernameFormats
+	UsernameFormat string
+	// The names of the username and password inputs and a CSS selector for the
+	// login form, for portals where they can't be discovered from the input types
+	UsernameField string
+	PasswordField string
+	FormSelector  string
+	// Path to a CA bundle for the ADFS server
+	CABundle string
+	// Verify the assertion signature, time window, audience and destination against
+	// IdpCertificate or IdpMetadata, one of which is then required. It is off by
+	// default, in which case the roles are trusted as returned by the portal, so
+	// enable it unless the IdP entry URL is fully trusted.
+	ValidateAssertion bool
+	IdpCertificate    string
+	IdpMetadata       string
+	// The AWS region of the STS endpoint, not needed with a StsClient
+	Region string
+	// The session duration to request, DefaultDuration when zero
+	Duration time.Duration
+	// The STS client, created from the default AWS configuration when nil
+	StsClient auth.StsApi
+	// The logger, which discards its output when nil
+	Logger *logrus.Logger
+}
+
+// A SAML assertion returned by the ADFS portal.
+type Assertion struct {
+	// The base64 encoded SAMLResponse posted to AWS
+	Raw string
+	// The roles the assertion grants
+	Roles []Role
+	// The AWS attributes carried by the assertion
+	Attributes saml.AwsAttributes
+	// When AWS stops accepting the assertion, zero when it doesn't say
+	Expires time.Time
+}
+
+// Whether AWS no longer accepts the assertion.
+func (a *Assertion) expired(now time.Time) bool {
+	return !a.Expires.IsZero() && !now.Before(a.Expires)
+}
+
+// Signs in to ADFS and assumes the roles granted by the assertion, safe for
+// concurrent use.
+type Client struct {
+	options Options
+	// The clock, replaced in tests
+	now       func() time.Time
+	mu        sync.Mutex
+	assertion *Assertion
+}
+
+// Creates a client from the options.
+func New(options Options) (*Client, error) {
+	if !strings.HasPrefix(options.IdpEntryUrl, "https://") && !strings.HasPrefix(options.IdpEntryUrl, "http://") {
+		return nil, fmt.Errorf("invalid IdP entry URL %q, expected an http(s) URL", options.IdpEntryUrl)
+	}
+	if options.User.Username == "" || options.User.Password == "" {
+		return nil, errors.New("a username and password are required")
+	}
+	if options.Region == "" && options.StsClient == nil {
+		return nil, errors.New("an AWS region is required")
+	}
+	if options.Duration == 0 {
+		options.Duration = DefaultDuration
+	}
+	if options.Logger == nil {
+		options.Logger = logging.New()
+		options.Logger.SetOutput(io.Discard)
+	}
+	logging.AddSecret(options.Logger, options.User.Password)
+	return &Client{options: options, now: time.Now}, nil
+}
+
+// Signs in to the ADFS portal and returns the SAML assertion, which is kept for
+// AssumeRole. Failures are of the ErrNetwork, ErrAuthFailed or ErrNoRoles kinds.
+func (c *Client) Authenticate(ctx context.Context) (*Assertion, error) {
+	s := saml.Saml{
+		IdpEntryUrl:       c.options.IdpEntryUrl,
+		CABundle:          c.options.CABundle,
+		UsernameFormat:    c.options.UsernameFormat,
+		UsernameField:     c.options.UsernameField,
+		PasswordField:     c.options.PasswordField,
+		FormSelector:      c.options.FormSelector,
+		ValidateAssertion: c.options.ValidateAssertion,
+		IdpCertificate:    c.options.IdpCertificate,
+		IdpMetadata:       c.options.IdpMetadata,
+		User:              c.options.User,
+		Logger:            c.options.Logger,
+	}
+	if err := s.Verify(ctx); err != nil {
+		return nil, err
+	}
+	assertion := &Assertion{Raw: s.Assertion, Roles: s.Roles, Attributes: s.Attributes}
+	if notOnOrAfter := s.SamlXMLResponse.Conditions.NotOnOrAfter; notOnOrAfter != "" {
+		expires, err := time.Parse(time.RFC3339, notOnOrAfter)
+		if err != nil {
+			c.options.Logger.Warnf("Ignoring invalid SAML NotOnOrAfter %q", notOnOrAfter)
+		}
+		assertion.Expires = expires
+	}
+	c.mu.Lock()
+	c.assertion = assertion
+	c.mu.Unlock()
+	return assertion, nil
+}
+
+// The roles granted by the last assertion, nil before Authenticate.
+func (c *Client) Roles() []Role {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.assertion == nil {
+		return nil
+	}
+	return append([]Role(nil), c.assertion.Roles...)
+}
+
+// Assumes the role, given by its ARN in Role.Name, with the last assertion,
+// authenticating first when there is none or it has expired, which ADFS assertions
+// usually do within minutes. Failures are of the ErrStsDenied or ErrNetwork kinds,
+// or ErrNoRoles when the assertion doesn't grant the role.
+func (c *Client) AssumeRole(ctx context.Context, role Role) (aws.Credentials, error) {
+	c.mu.Lock()
+	assertion := c.assertion
+	c.mu.Unlock()
+	if assertion == nil || assertion.expired(c.now()) {
+		var err error
+		if assertion, err = c.Authenticate(ctx); err != nil {
+			return aws.Credentials{}, err
+		}
+	}
+	return c.assumeRole(ctx, assertion, role)
+}
+
+// Assumes the role with the assertion.
+func (c *Client) assumeRole(ctx context.Context, assertion *Assertion, role Role) (aws.Credentials, error) {
+	granted, ok := findRole(assertion.Roles, role.Name)
+	if !ok {
+		return aws.Credentials{}, types.NewError(types.ErrNoRoles, "assuming role "+role.Name+": the SAML assertion doesn't grant it", nil)
+	}
+	stsClient, err := c.stsClient(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	cli := auth.CLI{StsClient: stsClient, Logger: c.options.Logger}
+	output, _, err := cli.RoleCredentials(ctx, granted, int32(c.options.Duration/time.Second), assertion.Raw)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	creds := output.Credentials
+	// The SDK treats credentials that can expire without an expiry as expired
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		Source:          CredentialsSource,
+		CanExpire:       creds.Expiration != nil,
+		Expires:         aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+// A caching aws.CredentialsProvider for the role, see NewProvider.
+func (c *Client) CredentialsProvider(role Role) aws.CredentialsProvider {
+	return NewProvider(c, role)
+}
+
+// Stops redacting the credentials the current ones replaced, except for values
+// the current credentials still use.
+func (c *Client) forgetCredentials(replaced aws.Credentials, current aws.Credentials) {
+	for _, pair := range [][2]string{
+		{replaced.AccessKeyID, current.AccessKeyID},
+		{replaced.SecretAccessKey, current.SecretAccessKey},
+		{replaced.SessionToken, current.SessionToken},
+	} {
+		if pair[0] != pair[1] {
+			logging.RemoveSecret(c.options.Logger, pair[0])
+		}
+	}
+}
+
+// The STS client from the options, or one created from the default AWS configuration.
+func (c *Client) stsClient(ctx context.Context) (auth.StsApi, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.options.StsClient == nil {
+		stsClient, err := auth.NewStsClient(ctx, c.options.Region)
+		if err != nil {
+			return nil, err
+		}
+		c.options.StsClient = stsClient
+	}
+	return c.options.StsClient, nil
+}
+
+// Finds the role by its ARN, matching case insensitively as IAM does.
+func findRole(roles []Role, arn string) (Role, bool) {
+	for _, role := range roles {
+		if strings.EqualFold(role.Name, arn) {
+			return role, true
+		}
+	}
+	return Role{}, false
+}
@@ -0,0 +1,258 @@
+package adfslogin
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	testLoginPage    = "../tests/login-page.html"
+	testCustomPage   = "../tests/login-page-custom.html"
+	testLoginSuccess = "../tests/login-success.html"
+	testAdminRole    = "arn:aws:iam::123456789123:role/AdministratorAccess"
+)
+
+type mockStsClient func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error)
+
+func (m mockStsClient) AssumeRoleWithSAML(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+	return m(ctx, params, optFns...)
+}
+
+func (m mockStsClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return nil, errors.New("AssumeRole not mocked")
+}
+
+// Serves the login page on / and the SAML response on every other path,
+// counting the logins submitted.
+func newPortal(t *testing.T, logins *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			testBody, _ := ioutil.ReadFile(testLoginPage)
+			rw.Write(testBody)
+			return
+		}
+		atomic.AddInt32(logins, 1)
+		testBody, _ := ioutil.ReadFile(testLoginSuccess)
+		rw.Write(testBody)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func grantingSts(expiration time.Time) mockStsClient {
+	return func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		return &sts.AssumeRoleWithSAMLOutput{
+			Credentials: &stsTypes.Credentials{
+				AccessKeyId:     aws.String("someaccesskeyid"),
+				SecretAccessKey: aws.String("somesecretaccesskey"),
+				SessionToken:    aws.String(*params.RoleArn),
+				Expiration:      aws.Time(expiration),
+			},
+		}, nil
+	}
+}
+
+func Test_New(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{
+			name:    "Valid options create a client...",
+			options: Options{IdpEntryUrl: "https://adfs.example.com/adfs/ls/", User: User{Username: "user", Password: "password"}, Region: "us-east-1"},
+		},
+		{
+			name:    "A STS client replaces the region...",
+			options: Options{IdpEntryUrl: "https://adfs.example.com/adfs/ls/", User: User{Username: "user", Password: "password"}, StsClient: grantingSts(time.Now())},
+		},
+		{
+			name:    "Missing region is rejected...",
+			options: Options{IdpEntryUrl: "https://adfs.example.com/adfs/ls/", User: User{Username: "user", Password: "password"}},
+			wantErr: true,
+		},
+		{
+			name:    "URL without a scheme is rejected...",
+			options: Options{IdpEntryUrl: "adfs.example.com", User: User{Username: "user", Password: "password"}},
+			wantErr: true,
+		},
+		{
+			name:    "Missing password is rejected...",
+			options: Options{IdpEntryUrl: "https://adfs.example.com/adfs/ls/", User: User{Username: "user"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		client, err := New(tt.options)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got: %v want error: %v", err, tt.wantErr)
+		}
+		if err == nil && client.options.Duration != DefaultDuration {
+			t.Errorf("Error running test -- got: %v want: %v", client.options.Duration, DefaultDuration)
+		}
+	}
+}
+
+func Test_Authenticate(t *testing.T) {
+	var logins int32
+	server := newPortal(t, &logins)
+	client, err := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Error creating client -- %v", err)
+	}
+	if roles := client.Roles(); roles != nil {
+		t.Errorf("Error running test -- got: %v want: no roles before Authenticate", roles)
+	}
+	assertion, err := client.Authenticate(context.Background())
+	if err != nil {
+		t.Fatalf("Error authenticating -- %v", err)
+	}
+	if assertion.Raw == "" {
+		t.Errorf("Error running test -- got an empty assertion")
+	}
+	if len(assertion.Roles) != 2 || len(client.Roles()) != 2 {
+		t.Errorf("Error running test -- got: %v want: 2 roles", client.Roles())
+	}
+}
+
+func Test_Authenticate_Form_Overrides(t *testing.T) {
+	var posted url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			testBody, _ := ioutil.ReadFile(testCustomPage)
+			rw.Write(testBody)
+			return
+		}
+		req.ParseForm()
+		posted = req.PostForm
+		testBody, _ := ioutil.ReadFile(testLoginSuccess)
+		rw.Write(testBody)
+	}))
+	defer server.Close()
+	client, _ := New(Options{
+		IdpEntryUrl:   server.URL,
+		User:          User{Username: "user", Password: "password"},
+		UsernameField: "acct",
+		PasswordField: "pin",
+		FormSelector:  "#corpLogin",
+		Region:        "us-east-1",
+	})
+
+	t.Logf("Running test -- %s", "The form selector and field overrides are used to sign in...")
+	if _, err := client.Authenticate(context.Background()); err != nil {
+		t.Fatalf("Error authenticating -- %v", err)
+	}
+	if posted.Get("acct") != "user" || posted.Get("pin") != "password" {
+		t.Errorf("Error running test -- got: %v want: the credentials in acct and pin", posted)
+	}
+}
+
+func Test_Assume_Role(t *testing.T) {
+	var logins int32
+	server := newPortal(t, &logins)
+	expiration := time.Now().Add(time.Hour).UTC()
+	denied := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Not authorized to perform sts:AssumeRoleWithSAML"}
+	})
+
+	tests := []struct {
+		name      string
+		role      string
+		stsClient mockStsClient
+		want      error
+	}{
+		{
+			name:      "Granted role returns credentials...",
+			role:      testAdminRole,
+			stsClient: grantingSts(expiration),
+		},
+		{
+			name:      "Role missing from the assertion is a no roles error...",
+			role:      "arn:aws:iam::123456789123:role/Missing",
+			stsClient: grantingSts(expiration),
+			want:      ErrNoRoles,
+		},
+		{
+			name:      "STS rejection is an STS denied error...",
+			role:      testAdminRole,
+			stsClient: denied,
+			want:      ErrStsDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: tt.stsClient})
+		creds, err := client.AssumeRole(context.Background(), Role{Name: tt.role})
+		if tt.want != nil {
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Error running test -- got: %v want: %v", err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error running test -- got: %v want: no error", err)
+			continue
+		}
+		if creds.SessionToken != tt.role || !creds.CanExpire || !creds.Expires.Equal(expiration) || creds.Source != CredentialsSource {
+			t.Errorf("Error running test -- got: %+v", creds)
+		}
+	}
+}
+
+func Test_Assume_Role_Assertion_Expiry(t *testing.T) {
+	var logins int32
+	server := newPortal(t, &logins)
+	client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: grantingSts(time.Now().Add(time.Hour))})
+	// The test assertion is valid until 2016-10-08T06:40:41.886Z
+	clock := time.Date(2016, 10, 8, 6, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return clock }
+
+	tests := []struct {
+		name       string
+		now        time.Time
+		wantLogins int32
+	}{
+		{
+			name:       "First assume role signs in...",
+			now:        clock,
+			wantLogins: 1,
+		},
+		{
+			name:       "The assertion is reused until it expires...",
+			now:        clock.Add(40 * time.Minute),
+			wantLogins: 1,
+		},
+		{
+			name:       "An expired assertion signs in again...",
+			now:        clock.Add(41 * time.Minute),
+			wantLogins: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		clock = tt.now
+		if _, err := client.AssumeRole(context.Background(), Role{Name: testAdminRole}); err != nil {
+			t.Errorf("Error running test -- got: %v want: no error", err)
+		}
+		if got := atomic.LoadInt32(&logins); got != tt.wantLogins {
+			t.Errorf("Error running test -- got: %v logins want: %v", got, tt.wantLogins)
+		}
+	}
+	want := time.Date(2016, 10, 8, 6, 40, 41, 886000000, time.UTC)
+	if assertion := client.assertion; !assertion.Expires.Equal(want) {
+		t.Errorf("Error running test -- got: %v want: %v", assertion.Expires, want)
+	}
+}
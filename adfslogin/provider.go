@@ -0,0 +1,87 @@
+package adfslogin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// How long before they expire the credentials of a Provider are refreshed
+const DefaultExpiryWindow = 5 * time.Minute
+
+// An aws.CredentialsProvider that signs in to ADFS and assumes the role, reusing
+// the credentials until they are within ExpiryWindow of expiring and then signing
+// in again for a fresh assertion. The credentials are reported as expiring
+// ExpiryWindow early, so the aws.CredentialsCache that config.WithCredentialsProvider
+// wraps the provider in asks for new ones in time. It is safe for concurrent use,
+// concurrent callers share a single refresh. The zero value with a Client and Role
+// is usable and refreshes the credentials when they expire.
+type Provider struct {
+	Client       *Client
+	Role         Role
+	ExpiryWindow time.Duration
+	// The clock, replaced in tests
+	now   func() time.Time
+	mu    sync.Mutex
+	creds aws.Credentials
+}
+
+// Creates a provider for the role with the DefaultExpiryWindow.
+func NewProvider(client *Client, role Role) *Provider {
+	return &Provider{
+		Client:       client,
+		Role:         role,
+		ExpiryWindow: DefaultExpiryWindow,
+		now:          time.Now,
+	}
+}
+
+// Returns the cached credentials, or signs in and assumes the role when there are
+// none or they are about to expire.
+func (p *Provider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.valid() {
+		return p.creds, nil
+	}
+	assertion, err := p.Client.Authenticate(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	creds, err := p.Client.assumeRole(ctx, assertion, p.Role)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	p.Client.forgetCredentials(p.creds, creds)
+	if creds.CanExpire {
+		creds.Expires = creds.Expires.Add(-p.ExpiryWindow)
+	}
+	p.creds = creds
+	return creds, nil
+}
+
+// Drops the cached credentials so the next Retrieve signs in again.
+func (p *Provider) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Client.forgetCredentials(p.creds, aws.Credentials{})
+	p.creds = aws.Credentials{}
+}
+
+// Whether the cached credentials are usable for longer than the expiry window,
+// which their expiry already accounts for.
+func (p *Provider) valid() bool {
+	if !p.creds.HasKeys() {
+		return false
+	}
+	if !p.creds.CanExpire {
+		return true
+	}
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+	return now().Before(p.creds.Expires)
+}
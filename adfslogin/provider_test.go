@@ -0,0 +1,252 @@
+package adfslogin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/logging"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// A clock that only moves when the test advances it
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func Test_Provider_Refresh(t *testing.T) {
+	var logins, calls int32
+	server := newPortal(t, &logins)
+	clock := &fakeClock{now: time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)}
+	failing := false
+	stsClient := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		if failing {
+			return nil, errors.New("connection reset")
+		}
+		n := atomic.AddInt32(&calls, 1)
+		return &sts.AssumeRoleWithSAMLOutput{
+			Credentials: &stsTypes.Credentials{
+				AccessKeyId:     aws.String("someaccesskeyid"),
+				SecretAccessKey: aws.String("somesecretaccesskey"),
+				SessionToken:    aws.String(strconv.Itoa(int(n))),
+				Expiration:      aws.Time(clock.Now().Add(time.Hour)),
+			},
+		}, nil
+	})
+	client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: stsClient})
+	provider := NewProvider(client, Role{Name: testAdminRole})
+	provider.now = clock.Now
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		failing    bool
+		wantToken  string
+		wantLogins int32
+		wantErr    error
+	}{
+		{
+			name:       "First retrieve signs in...",
+			wantToken:  "1",
+			wantLogins: 1,
+		},
+		{
+			name:       "Valid credentials are reused...",
+			advance:    30 * time.Minute,
+			wantToken:  "1",
+			wantLogins: 1,
+		},
+		{
+			name:       "Credentials within the expiry window are refreshed...",
+			advance:    26 * time.Minute,
+			wantToken:  "2",
+			wantLogins: 2,
+		},
+		{
+			name:       "Refreshed credentials are reused...",
+			advance:    time.Minute,
+			wantToken:  "2",
+			wantLogins: 2,
+		},
+		{
+			name:       "Failed refresh returns the error...",
+			advance:    2 * time.Hour,
+			failing:    true,
+			wantLogins: 3,
+			wantErr:    ErrNetwork,
+		},
+		{
+			name:       "Refresh after a failure signs in again...",
+			wantToken:  "3",
+			wantLogins: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		clock.Advance(tt.advance)
+		failing = tt.failing
+		creds, err := provider.Retrieve(context.Background())
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Error running test -- got: %v want: %v", err, tt.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("Error running test -- got: %v want: no error", err)
+		} else if creds.SessionToken != tt.wantToken {
+			t.Errorf("Error running test -- got: %v want: %v", creds.SessionToken, tt.wantToken)
+		}
+		if got := atomic.LoadInt32(&logins); got != tt.wantLogins {
+			t.Errorf("Error running test -- got: %v logins want: %v", got, tt.wantLogins)
+		}
+	}
+}
+
+func Test_Provider_Expiry_Window(t *testing.T) {
+	var logins int32
+	server := newPortal(t, &logins)
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: grantingSts(expiration)})
+
+	tests := []struct {
+		name     string
+		provider *Provider
+		want     time.Time
+	}{
+		{
+			name:     "Credentials are reported as expiring the expiry window early...",
+			provider: NewProvider(client, Role{Name: testAdminRole}),
+			want:     expiration.Add(-DefaultExpiryWindow),
+		},
+		{
+			name:     "A zero value provider reports the STS expiration...",
+			provider: &Provider{Client: client, Role: Role{Name: testAdminRole}},
+			want:     expiration,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		creds, err := aws.NewCredentialsCache(tt.provider).Retrieve(context.Background())
+		if err != nil || !creds.Expires.Equal(tt.want) {
+			t.Errorf("Error running test -- got: %v (%v) want: %v", creds.Expires, err, tt.want)
+		}
+		if _, err := tt.provider.Retrieve(context.Background()); err != nil {
+			t.Errorf("Error running test -- got: %v want: no error", err)
+		}
+	}
+	if got := atomic.LoadInt32(&logins); got != 2 {
+		t.Errorf("Error running test -- got: %v logins want: 2", got)
+	}
+}
+
+func Test_Provider_Without_Expiration(t *testing.T) {
+	var logins int32
+	server := newPortal(t, &logins)
+	stsClient := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		return &sts.AssumeRoleWithSAMLOutput{
+			Credentials: &stsTypes.Credentials{
+				AccessKeyId:     aws.String("someaccesskeyid"),
+				SecretAccessKey: aws.String("somesecretaccesskey"),
+				SessionToken:    aws.String("somesessiontoken"),
+			},
+		}, nil
+	})
+	client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: stsClient})
+	cache := aws.NewCredentialsCache(NewProvider(client, Role{Name: testAdminRole}))
+
+	t.Logf("Running test -- %s", "Credentials without an expiration are reused...")
+	for i := 0; i < 3; i++ {
+		creds, err := cache.Retrieve(context.Background())
+		if err != nil || creds.CanExpire {
+			t.Errorf("Error running test -- got: %v (%v) want: credentials that can't expire", creds, err)
+		}
+	}
+	if got := atomic.LoadInt32(&logins); got != 1 {
+		t.Errorf("Error running test -- got: %v logins want: 1", got)
+	}
+}
+
+func Test_Provider_Concurrent(t *testing.T) {
+	var logins int32
+	server := newPortal(t, &logins)
+	client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: grantingSts(time.Now().Add(time.Hour))})
+	var provider aws.CredentialsProvider = client.CredentialsProvider(Role{Name: testAdminRole})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.Retrieve(context.Background()); err != nil {
+				t.Errorf("Error retrieving credentials -- %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&logins); got != 1 {
+		t.Errorf("Error running test -- got: %v logins want: 1", got)
+	}
+}
+
+func Test_Provider_Invalidate(t *testing.T) {
+	var logins int32
+	server := newPortal(t, &logins)
+	client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: grantingSts(time.Now().Add(time.Hour))})
+	provider := NewProvider(client, Role{Name: testAdminRole})
+
+	provider.Retrieve(context.Background())
+	provider.Invalidate()
+	provider.Retrieve(context.Background())
+	if got := atomic.LoadInt32(&logins); got != 2 {
+		t.Errorf("Error running test -- got: %v logins want: 2", got)
+	}
+}
+
+func Test_Provider_Forgets_Replaced_Credentials(t *testing.T) {
+	var logins, calls int32
+	server := newPortal(t, &logins)
+	stsClient := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		n := strconv.Itoa(int(atomic.AddInt32(&calls, 1)))
+		return &sts.AssumeRoleWithSAMLOutput{
+			Credentials: &stsTypes.Credentials{
+				AccessKeyId:     aws.String("accesskeyid-" + n),
+				SecretAccessKey: aws.String("secretaccesskey-" + n),
+				SessionToken:    aws.String("sessiontoken-" + n),
+				Expiration:      aws.Time(time.Now().Add(time.Hour)),
+			},
+		}, nil
+	})
+	var output bytes.Buffer
+	logger := logging.New()
+	logger.SetOutput(&output)
+	client, _ := New(Options{IdpEntryUrl: server.URL, User: User{Username: "user", Password: "password"}, StsClient: stsClient, Logger: logger})
+	provider := NewProvider(client, Role{Name: testAdminRole})
+
+	provider.Retrieve(context.Background())
+	provider.Invalidate()
+	provider.Retrieve(context.Background())
+	logger.Info("sessiontoken-1 sessiontoken-2")
+	if got := output.String(); !strings.Contains(got, "sessiontoken-1 [REDACTED]") {
+		t.Errorf("Error running test -- got: %v want: %v", got, "sessiontoken-1 [REDACTED]")
+	}
+}
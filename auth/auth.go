@@ -1,29 +1,49 @@
 package auth
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 	"text/template"
 
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/keyring"
+	"github.com/S7R4nG3/aws-adfs-login/logging"
 	"github.com/S7R4nG3/aws-adfs-login/prompts"
 	"github.com/S7R4nG3/aws-adfs-login/saml"
 	"github.com/S7R4nG3/aws-adfs-login/types"
-	"github.com/S7R4nG3/aws-adfs-login/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
 	"github.com/sirupsen/logrus"
 )
 
 const (
 	credentialsFile = ".aws/credentials"
+	// STS never allows more than twelve hours
+	maxSessionDuration = 43200
 )
 
+// Where --password-stdin reads the password from, replaced in tests.
+var stdin io.Reader = os.Stdin
+
+// Progressively shorter durations retried when STS rejects the requested
+// duration for exceeding the role's MaxSessionDuration.
+var fallbackDurations = []int32{43200, 28800, 14400, 3600}
+
 // Work in progress to mock the STS Client calls...
 type StsApi interface {
 	AssumeRoleWithSAML(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error)
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
 }
 
 type StsNewConfig interface {
@@ -32,106 +52,509 @@ type StsNewConfig interface {
 
 // A generic CLI struct used to contain the CLI flag values and shared logger
 type CLI struct {
-	Region      string
-	Duration    int
-	Profile     string
-	IdpEntryUrl string
-	CABundle    string
-	AWSRole     types.Role
-	StsClient   StsApi
-	StsCreds    sts.AssumeRoleWithSAMLOutput
-	Logger      *logrus.Logger
+	Region            string
+	Duration          int
+	DurationMax       bool
+	Profile           string
+	IdpEntryUrl       string
+	CABundle          string
+	UsernameFormat    string
+	UsernameField     string
+	PasswordField     string
+	FormSelector      string
+	ValidateAssertion bool
+	IdpCertificate    string
+	IdpMetadata       string
+	ResolveAliases    bool
+	SigninUrl         string
+	AccountAliases    map[string]string
+	RoleFilter        string
+	AccountFilter     string
+	UseLast           bool
+	StatePath         string
+	CredentialsFile   string
+	MultiSelect       bool
+	RoleMap           map[string]string
+	Parallelism       int
+	ChainRoles        []ChainRole
+	User              types.User
+	PasswordStdin     bool
+	PasswordFile      string
+	Keyring           keyring.Keyring
+	SavePassword      bool
+	AWSRole           types.Role
+	StsClient         StsApi
+	StsCreds          sts.AssumeRoleWithSAMLOutput
+	Logger            *logrus.Logger
 }
 
 // The main login function - this orchestrations login and SAML verification
 // then configures the AWS credentials file with the credentials returned by the
-// AWS STS service.
-func (cli CLI) Login() {
+// AWS STS service. Failures are returned as types.LoginError values where their
+// kind is known.
+func (cli CLI) Login(ctx context.Context) error {
 	log := cli.Logger
+	if (len(cli.RoleMap) > 0 || cli.MultiSelect) && len(cli.ChainRoles) > 0 {
+		return errors.New("selecting AWS roles: --chain-role can't be combined with --role-map or --multiple")
+	}
 	fmt.Println(types.Header)
-	log.Info("Starting authentication...")
-	cli.setupCredentials()
-	saml := saml.Saml{
-		IdpEntryUrl: cli.IdpEntryUrl,
-		Logger:      log,
+	saml, err := cli.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	duration := cli.sessionDuration(saml)
+	if cli.StsClient == nil {
+		if cli.StsClient, err = NewStsClient(ctx, cli.Region); err != nil {
+			return err
+		}
 	}
-	saml.Verify()
+	if len(cli.RoleMap) > 0 || cli.MultiSelect {
+		if err := cli.loginMultiple(ctx, saml.Roles, duration, saml.Assertion); err != nil {
+			return err
+		}
+		log.Info("Login Complete!")
+		return nil
+	}
+
+	state, err := config.LoadState(cli.StatePath)
+	if err != nil {
+		log.Warnf("Unable to load the last used roles -- %v", err)
+	}
+	stateKey := config.StateKey(cli.IdpEntryUrl, cli.Profile)
 	if cli.AWSRole.Name == "" {
-		cli.AWSRole = prompts.RoleSelect(types.Roles)
+		role, err := cli.selectRole(saml.Roles, state.LastRoles[stateKey])
+		if err != nil {
+			return fmt.Errorf("selecting AWS role: %w", err)
+		}
+		cli.AWSRole = role
 	}
-	duration := int32(cli.Duration)
-	if cli.StsClient == nil {
-		awsSession, _ := config.LoadDefaultConfig(context.TODO(), config.WithRegion(cli.Region))
-		cli.StsClient = sts.NewFromConfig(awsSession)
+	creds, err := cli.getStsCredentials(ctx, duration, saml.Assertion)
+	if err != nil {
+		return err
+	}
+	if len(cli.ChainRoles) > 0 {
+		creds, err = cli.chainRoles(ctx, creds, duration, chainSessionName(saml.Attributes.RoleSessionName))
+		if err != nil {
+			return fmt.Errorf("assuming chained role: %w", err)
+		}
 	}
-	creds := cli.getStsCredentials(duration, saml.Assertion)
 	content := writeCredentials(*creds, duration, cli.Profile, cli.Region)
-	dirname, err := os.UserHomeDir()
-	utils.Check(err, "Error locating user home directory...")
-	credFilePath := dirname + credentialsFile
-	f, _ := os.Create(credFilePath)
-	defer f.Close()
-	f.Write([]byte(content))
+	if err := cli.writeCredentialsFile(content); err != nil {
+		return err
+	}
+	state.LastRoles[stateKey] = cli.AWSRole.Name
+	if err := state.Save(cli.StatePath); err != nil {
+		log.Warnf("Unable to remember the selected role -- %v", err)
+	}
 	log.Info("Login Complete!")
+	return nil
+}
+
+// Signs into the ADFS portal and returns the verified SAML response, with the
+// roles it grants parsed into its Roles.
+func (cli CLI) authenticate(ctx context.Context) (saml.Saml, error) {
+	log := cli.Logger
+	log.Info("Starting authentication...")
+	user, err := cli.setupCredentials()
+	if err != nil {
+		return saml.Saml{}, err
+	}
+	saml := saml.Saml{
+		IdpEntryUrl:       cli.IdpEntryUrl,
+		CABundle:          cli.CABundle,
+		UsernameFormat:    cli.UsernameFormat,
+		UsernameField:     cli.UsernameField,
+		PasswordField:     cli.PasswordField,
+		FormSelector:      cli.FormSelector,
+		ValidateAssertion: cli.ValidateAssertion,
+		IdpCertificate:    cli.IdpCertificate,
+		IdpMetadata:       cli.IdpMetadata,
+		SigninUrl:         cli.SigninUrl,
+		User:              user,
+		Logger:            log,
+	}
+	if err := saml.Verify(ctx); err != nil {
+		return saml, err
+	}
+	if cli.SavePassword {
+		cli.savePassword(user)
+	}
+	cli.applyAccountAliases(ctx, &saml)
+	return saml, nil
+}
+
+// The session duration to request, the --duration or with --duration max the
+// SessionDuration allowed by the IdP.
+func (cli CLI) sessionDuration(s saml.Saml) int32 {
+	duration := int32(cli.Duration)
+	if cli.DurationMax {
+		duration = maxSessionDuration
+		if s.Attributes.SessionDuration > 0 {
+			duration = int32(s.Attributes.SessionDuration)
+		}
+		cli.Logger.Infof("Requesting the maximum allowed session duration of %d seconds", duration)
+	}
+	return duration
+}
+
+// Creates an STS client for the region from the default AWS configuration.
+func NewStsClient(ctx context.Context, region string) (StsApi, error) {
+	awsSession, err := awsConfig.LoadDefaultConfig(ctx, awsConfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	return sts.NewFromConfig(awsSession), nil
 }
 
-func (cli CLI) getStsCredentials(duration int32, samlAssertion string) *sts.AssumeRoleWithSAMLOutput {
+func (cli CLI) getStsCredentials(ctx context.Context, duration int32, samlAssertion string) (*sts.AssumeRoleWithSAMLOutput, error) {
 	log := cli.Logger
 	log.Infof("Begin STS Credentials retrieval...")
+	creds, granted, err := cli.RoleCredentials(ctx, cli.AWSRole, duration, samlAssertion)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving AWS login content from STS: %w", err)
+	}
+	if granted != duration {
+		fmt.Printf("Requested session duration of %d seconds exceeds the role's maximum, granted %d seconds.\n", duration, granted)
+	}
+
+	log.Infof("STS Credential retrieval complete!")
+	return creds, nil
+}
+
+// Assumes the role with the SAML assertion, retrying with progressively shorter
+// durations when STS rejects the requested one, and returns the duration granted.
+// Failures are types.LoginError values of the ErrStsDenied or ErrNetwork kind.
+func (cli CLI) RoleCredentials(ctx context.Context, role types.Role, duration int32, samlAssertion string) (*sts.AssumeRoleWithSAMLOutput, int32, error) {
+	log := cli.Logger
+	granted := duration
+	creds, err := cli.assumeRoleWithSaml(ctx, role, granted, samlAssertion)
+	for _, fallback := range fallbackDurations {
+		if err == nil || !isDurationError(err) {
+			break
+		}
+		if fallback >= granted {
+			continue
+		}
+		log.Warnf("STS rejected a %d second session for %s, retrying with %d seconds", granted, role.Name, fallback)
+		granted = fallback
+		creds, err = cli.assumeRoleWithSaml(ctx, role, granted, samlAssertion)
+	}
+	if err != nil {
+		return nil, granted, types.NewError(stsErrorKind(err), "", err)
+	}
+	addCredentialSecrets(log, creds.Credentials)
+	return creds, granted, nil
+}
+
+func (cli CLI) assumeRoleWithSaml(ctx context.Context, role types.Role, duration int32, samlAssertion string) (*sts.AssumeRoleWithSAMLOutput, error) {
 	assumeRoleInput := sts.AssumeRoleWithSAMLInput{
 		DurationSeconds: &duration,
-		PrincipalArn:    &cli.AWSRole.PrincipalArn,
-		RoleArn:         &cli.AWSRole.Name,
+		PrincipalArn:    &role.PrincipalArn,
+		RoleArn:         &role.Name,
 		SAMLAssertion:   &samlAssertion,
 	}
+	return cli.StsClient.AssumeRoleWithSAML(ctx, &assumeRoleInput)
+}
 
-	creds, err := cli.StsClient.AssumeRoleWithSAML(context.TODO(), &assumeRoleInput)
-	utils.Check(err, "Error retrieving AWS login content from STS")
+// Writes the profiles in the content to the credentials file, by default
+// ~/.aws/credentials, replacing those profiles and keeping any other profile.
+func (cli CLI) writeCredentialsFile(content string) error {
+	credFilePath := cli.CredentialsFile
+	if credFilePath == "" {
+		dirname, err := os.UserHomeDir()
+		if err != nil {
+			return types.NewError(types.ErrWriteFailed, "locating user home directory", err)
+		}
+		credFilePath = filepath.Join(dirname, credentialsFile)
+	}
+	if err := os.MkdirAll(filepath.Dir(credFilePath), 0700); err != nil {
+		return types.NewError(types.ErrWriteFailed, "creating AWS credentials directory", err)
+	}
+	existing, err := os.ReadFile(credFilePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return types.NewError(types.ErrWriteFailed, "reading AWS credentials file", err)
+	}
+	// Replace the file in one rename so readers never see a partially written file
+	file, err := os.CreateTemp(filepath.Dir(credFilePath), ".credentials-*")
+	if err != nil {
+		return types.NewError(types.ErrWriteFailed, "writing AWS credentials file", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(mergeProfiles(string(existing), content))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), credFilePath)
+	}
+	if err != nil {
+		return types.NewError(types.ErrWriteFailed, "writing AWS credentials file", err)
+	}
+	return nil
+}
 
-	log.Infof("STS Credential retrieval complete!")
-	return creds
+// Replaces the profiles of the existing credentials file content that the new
+// content defines, keeping the other profiles and comments as they are, and adds
+// the new profiles at the end.
+func mergeProfiles(existing string, content string) string {
+	replaced := map[string]bool{}
+	for _, line := range strings.Split(content, "\n") {
+		if name, ok := profileHeader(line); ok {
+			replaced[name] = true
+		}
+	}
+	var kept []string
+	skipping := false
+	for _, line := range strings.Split(existing, "\n") {
+		if name, ok := profileHeader(line); ok {
+			skipping = replaced[name]
+		}
+		if !skipping {
+			kept = append(kept, line)
+		}
+	}
+	merged := strings.TrimRight(strings.Join(kept, "\n"), "\n")
+	if merged != "" {
+		merged += "\n"
+	}
+	return merged + strings.TrimLeft(content, "\n") + "\n"
+}
+
+// The profile name of a [profile] section header line.
+func profileHeader(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", false
+	}
+	return strings.TrimSpace(line[1 : len(line)-1]), true
+}
+
+// Redacts the temporary credentials from the log output.
+func addCredentialSecrets(log *logrus.Logger, creds *stsTypes.Credentials) {
+	if creds == nil {
+		return
+	}
+	logging.AddSecret(log, getPointerValue(creds.AccessKeyId))
+	logging.AddSecret(log, getPointerValue(creds.SecretAccessKey))
+	logging.AddSecret(log, getPointerValue(creds.SessionToken))
+}
+
+// Stops redacting the temporary credentials that the current ones replaced, so
+// long running processes don't accumulate secrets. Values the current credentials
+// still use stay redacted.
+func removeCredentialSecrets(log *logrus.Logger, replaced *stsTypes.Credentials, current *stsTypes.Credentials) {
+	if replaced == nil {
+		return
+	}
+	if current == nil {
+		current = &stsTypes.Credentials{}
+	}
+	for _, pair := range [][2]*string{
+		{replaced.AccessKeyId, current.AccessKeyId},
+		{replaced.SecretAccessKey, current.SecretAccessKey},
+		{replaced.SessionToken, current.SessionToken},
+	} {
+		if getPointerValue(pair[0]) != getPointerValue(pair[1]) {
+			logging.RemoveSecret(log, getPointerValue(pair[0]))
+		}
+	}
+}
+
+// The kind of an STS failure, an error returned by the STS API means the request
+// was denied while any other error means STS couldn't be reached.
+func stsErrorKind(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return types.ErrStsDenied
+	}
+	return types.ErrNetwork
+}
+
+// Identifies the STS validation error returned when the requested duration
+// exceeds the MaxSessionDuration of the role.
+func isDurationError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "ValidationError" && strings.Contains(apiErr.ErrorMessage(), "DurationSeconds")
+	}
+	return false
+}
+
+// Applies friendly account aliases to the parsed roles, preferring the names shown
+// on the AWS sign-in page and falling back to the configured alias map.
+func (cli CLI) applyAccountAliases(ctx context.Context, s *saml.Saml) {
+	log := cli.Logger
+	aliases := map[string]string{}
+	if cli.ResolveAliases {
+		resolved, err := s.AccountAliases(ctx)
+		if err != nil {
+			log.Warnf("Unable to resolve account aliases from AWS -- %v", err)
+		}
+		for account, alias := range resolved {
+			aliases[account] = alias
+		}
+	}
+	for i, role := range s.Roles {
+		alias, ok := aliases[role.AccountId()]
+		if !ok {
+			alias = cli.AccountAliases[role.AccountId()]
+		}
+		s.Roles[i].AccountAlias = alias
+	}
 }
 
 // Configures the user's username and password by first checking command line flags
-// then checking for environment variables, and finally prompting the user directly
-func (cli CLI) setupCredentials() {
+// then checking for environment variables, and finally prompting the user directly.
+// The domain isn't needed for the plain username format. Returns the User with the
+// missing values filled in.
+func (cli CLI) setupCredentials() (types.User, error) {
 	log := cli.Logger
-	if types.LoginUser.Username == "" {
+	login := cli.User
+	if err := cli.checkPasswordStdin(login); err != nil {
+		return login, err
+	}
+	if login.Username == "" {
 		log.Debug("Login username not set via command line flags, checking environment variables...")
 		user, exists := os.LookupEnv("AWS_USERNAME")
 		if !exists {
 			log.Debug("Unable to locate AWS_USERNAME environment variable, prompting user...")
-			user = prompts.Username()
+			var err error
+			if user, err = prompts.Username(); err != nil {
+				return login, err
+			}
 		}
-		types.LoginUser.Username = user
+		login.Username = user
 	} else {
 		log.Info("Login username provided via CLI flags.")
 	}
 
-	if types.LoginUser.Password == "" {
-		log.Debug("Login password not set via command line flags, checking environment variables...")
-		pass, exists := os.LookupEnv("AWS_PASSWORD")
+	if login.Password == "" {
+		pass, exists, err := cli.passwordInput()
+		if err != nil {
+			return login, fmt.Errorf("reading login password: %w", err)
+		}
+		if !exists {
+			log.Debug("Login password not set via command line flags, checking environment variables...")
+			pass, exists = os.LookupEnv("AWS_PASSWORD")
+		}
+		if !exists {
+			pass, exists = cli.keyringPassword(login)
+		}
 		if !exists {
 			log.Debug("Unable to locate AWS_PASSWORD environment variable, prompting user...")
-			pass = prompts.Password()
+			if pass, err = prompts.Password(); err != nil {
+				return login, err
+			}
 		}
-		types.LoginUser.Password = pass
+		login.Password = pass
 	} else {
 		log.Info("Login password set via CLI flags.")
 	}
 
-	if types.LoginUser.Domain == "" {
+	if login.Domain == "" && cli.UsernameFormat != saml.UsernameFormatPlain {
 		log.Debug("Login domain not set via command line flags, checking environment variables...")
 		domain, exists := os.LookupEnv("ADFS_DOMAIN")
 		if !exists {
 			log.Debug("Unable to locate ADFS_DOMAIN environment variable, prompting user...")
-			domain = prompts.Domain()
+			var err error
+			if domain, err = prompts.Domain(); err != nil {
+				return login, err
+			}
 		}
-		types.LoginUser.Domain = domain
+		login.Domain = domain
 	} else {
 		log.Info("Login ADFS domain set via CLI flags.")
 	}
-	log.Debugf("Setup credentials --> Username: %s :: Password: %s :: Domain: %s\n", types.LoginUser.Username, types.LoginUser.Password, types.LoginUser.Domain)
+	logging.AddSecret(log, login.Password)
+	log.Debugf("Setup credentials --> Username: %s :: Domain: %s", login.Username, login.Domain)
+	return login, nil
+}
+
+// The prompts read from stdin as well, so with --password-stdin the username and
+// domain must be set up front or the piped password would be read as one of them.
+func (cli CLI) checkPasswordStdin(login types.User) error {
+	if !cli.PasswordStdin || login.Password != "" {
+		return nil
+	}
+	if _, exists := os.LookupEnv("AWS_USERNAME"); login.Username == "" && !exists {
+		return errors.New("--password-stdin requires a username, set --username or AWS_USERNAME")
+	}
+	if _, exists := os.LookupEnv("ADFS_DOMAIN"); login.Domain == "" && !exists && cli.UsernameFormat != saml.UsernameFormatPlain {
+		return errors.New("--password-stdin requires a domain, set --domain or ADFS_DOMAIN")
+	}
+	return nil
+}
+
+// Reads the password from stdin with --password-stdin or from a file with --password-file,
+// up to the first line break.
+func (cli CLI) passwordInput() (string, bool, error) {
+	log := cli.Logger
+	var input io.Reader
+	switch {
+	case cli.PasswordStdin && cli.PasswordFile != "":
+		return "", false, errors.New("--password-stdin and --password-file can't be combined")
+	case cli.PasswordStdin:
+		log.Info("Reading login password from stdin.")
+		input = stdin
+	case cli.PasswordFile != "":
+		log.Info("Reading login password from file.")
+		file, err := os.Open(cli.PasswordFile)
+		if err != nil {
+			return "", false, err
+		}
+		defer file.Close()
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0077 != 0 {
+			log.Warnf("Password file %s is readable by other users", cli.PasswordFile)
+		}
+		input = file
+	default:
+		return "", false, nil
+	}
+	line, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	pass := strings.TrimRight(line, "\r\n")
+	if pass == "" {
+		return "", false, errors.New("the password input is empty")
+	}
+	return pass, true, nil
+}
+
+// Looks up the password of the login user in the keyring.
+func (cli CLI) keyringPassword(user types.User) (string, bool) {
+	log := cli.Logger
+	if cli.Keyring == nil {
+		return "", false
+	}
+	log.Debug("Login password not set via environment variables, checking the keyring...")
+	pass, err := cli.Keyring.Get(keyringAccount(cli.IdpEntryUrl, user))
+	if err != nil {
+		if !errors.Is(err, keyring.ErrNotFound) {
+			log.Warnf("Unable to read the password from the keyring -- %v", err)
+		}
+		return "", false
+	}
+	log.Info("Login password loaded from the keyring.")
+	return pass, true
+}
+
+// Saves the password of the login user to the keyring once the login succeeded.
+func (cli CLI) savePassword(user types.User) {
+	log := cli.Logger
+	if cli.Keyring == nil {
+		log.Errorf("Unable to save the password -- %v, choose one with --keyring", keyring.ErrUnavailable)
+		return
+	}
+	if err := cli.Keyring.Set(keyringAccount(cli.IdpEntryUrl, user), user.Password); err != nil {
+		log.Errorf("Unable to save the password to the keyring -- %v", err)
+		return
+	}
+	fmt.Println("Password saved to the keyring.")
+}
+
+func keyringAccount(idpEntryUrl string, user types.User) string {
+	return keyring.Account(idpEntryUrl, user.Domain, user.Username)
 }
 
 // Configurations the credentials file string to ensure the file is properly formatted with the
@@ -1,17 +1,27 @@
 package auth
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/S7R4nG3/aws-adfs-login/keyring"
+	"github.com/S7R4nG3/aws-adfs-login/logging"
+	"github.com/S7R4nG3/aws-adfs-login/saml"
 	"github.com/S7R4nG3/aws-adfs-login/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,6 +29,7 @@ const (
 	testLoginPage    = "../tests/login-page.html"
 	testLoginSuccess = "../tests/login-success.html"
 	testSamlResponse = "../tests/saml-response.xml"
+	testAwsSignin    = "../tests/aws-signin.html"
 )
 
 type creds struct {
@@ -33,6 +44,22 @@ func (m mockStsClient) AssumeRoleWithSAML(ctx context.Context, params *sts.Assum
 	return m(ctx, params, optFns...)
 }
 
+func (m mockStsClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return nil, errors.New("AssumeRole not mocked")
+}
+
+type mockAssumeRole func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+
+// Mocks both the SAML login and the chained AssumeRole calls
+type mockChainClient struct {
+	mockStsClient
+	assumeRole mockAssumeRole
+}
+
+func (m mockChainClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return m.assumeRole(ctx, params, optFns...)
+}
+
 func Test_Login(t *testing.T) {
 	accessKeyId := "someaccesskeyid"
 	secretAccessKey := "somesecretaccesskey"
@@ -93,10 +120,185 @@ func Test_Login(t *testing.T) {
 	for _, tt := range tests {
 		t.Logf("Running test -- %s", tt.name)
 		tt.input.StsClient = tt.stsclient()
-		types.LoginUser.Username = "potato"
-		types.LoginUser.Password = "cheese"
-		types.LoginUser.Domain = "domain"
-		tt.input.Login()
+		tt.input.CredentialsFile = filepath.Join(t.TempDir(), "credentials")
+		tt.input.User = types.User{Username: "potato", Password: "cheese", Domain: "domain"}
+		if err := tt.input.Login(context.Background()); err != nil {
+			t.Errorf("Error running test -- got: %v want: %v", err, nil)
+		}
+	}
+}
+
+func Test_Login_Redaction(t *testing.T) {
+	accessKeyId := "ASIAREDACTIONTEST"
+	secretAccessKey := "redactionsecretaccesskey"
+	sessionToken := "redactionsessiontoken"
+	expiration := time.Now().Add(time.Hour)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			testBody, _ := ioutil.ReadFile(testLoginPage)
+			rw.Write(testBody)
+		} else {
+			testBody, _ := ioutil.ReadFile(testLoginSuccess)
+			rw.Write(testBody)
+		}
+	}))
+	defer server.Close()
+	success, _ := ioutil.ReadFile(testLoginSuccess)
+	assertion := regexp.MustCompile(`name="SAMLResponse"\s+value="([^"]+)"`).FindSubmatch(success)
+	if assertion == nil {
+		t.Fatalf("No SAMLResponse found in %s", testLoginSuccess)
+	}
+
+	var output bytes.Buffer
+	logger := logging.New()
+	logger.SetOutput(&output)
+	logger.SetLevel(logrus.DebugLevel)
+	cli := CLI{
+		IdpEntryUrl:     server.URL,
+		Region:          "us-east-1",
+		Duration:        900,
+		Profile:         "default",
+		RoleFilter:      "AdministratorAccess",
+		CredentialsFile: filepath.Join(t.TempDir(), "credentials"),
+		StsClient: mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+			return &sts.AssumeRoleWithSAMLOutput{
+				Credentials: &stsTypes.Credentials{
+					AccessKeyId:     &accessKeyId,
+					SecretAccessKey: &secretAccessKey,
+					SessionToken:    &sessionToken,
+					Expiration:      &expiration,
+				},
+			}, nil
+		}),
+		Logger: logger,
+	}
+	cli.User = types.User{Username: "potato", Password: "cheese", Domain: "domain"}
+	if err := cli.Login(context.Background()); err != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, nil)
+	}
+	if output.Len() == 0 {
+		t.Errorf("Error running test -- got: no debug output want: debug output")
+	}
+	secrets := map[string]string{
+		"password":          "cheese",
+		"access key":        accessKeyId,
+		"secret access key": secretAccessKey,
+		"session token":     sessionToken,
+		"SAML assertion":    string(assertion[1]),
+	}
+	for name, secret := range secrets {
+		t.Logf("Running test -- %s is redacted", name)
+		if strings.Contains(output.String(), secret) {
+			t.Errorf("Error running test -- got: %s in the log output want: [REDACTED]", name)
+		}
+	}
+}
+
+func Test_Login_Errors(t *testing.T) {
+	accessKeyId := "someaccesskeyid"
+	secretAccessKey := "somesecretaccesskey"
+	sessionToken := "somesessiontoken"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			testBody, _ := ioutil.ReadFile(testLoginPage)
+			rw.Write(testBody)
+		} else {
+			testBody, _ := ioutil.ReadFile(testLoginSuccess)
+			rw.Write(testBody)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		testBody, _ := ioutil.ReadFile(testLoginPage)
+		rw.Write(testBody)
+	}))
+	defer rejecting.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	granted := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		return &sts.AssumeRoleWithSAMLOutput{
+			Credentials: &stsTypes.Credentials{
+				AccessKeyId:     &accessKeyId,
+				SecretAccessKey: &secretAccessKey,
+				SessionToken:    &sessionToken,
+			},
+		}, nil
+	})
+	denied := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Not authorized to perform sts:AssumeRoleWithSAML"}
+	})
+	notAFile := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(notAFile, []byte{}, 0600)
+
+	tests := []struct {
+		name            string
+		idpEntryUrl     string
+		roleFilter      string
+		stsClient       StsApi
+		credentialsFile string
+		want            error
+	}{
+		{
+			name:        "Unreachable login portal is a network error...",
+			idpEntryUrl: closed.URL,
+			stsClient:   granted,
+			want:        types.ErrNetwork,
+		},
+		{
+			name:        "Login response without an assertion is an authentication failure...",
+			idpEntryUrl: rejecting.URL,
+			stsClient:   granted,
+			want:        types.ErrAuthFailed,
+		},
+		{
+			name:        "Role filter without a match is a no roles error...",
+			idpEntryUrl: server.URL,
+			roleFilter:  "ReadOnly",
+			stsClient:   granted,
+			want:        types.ErrNoRoles,
+		},
+		{
+			name:        "STS API error is an STS denied error...",
+			idpEntryUrl: server.URL,
+			stsClient:   denied,
+			want:        types.ErrStsDenied,
+		},
+		{
+			name:            "Unwritable credentials file is a write error...",
+			idpEntryUrl:     server.URL,
+			stsClient:       granted,
+			credentialsFile: filepath.Join(notAFile, "credentials"),
+			want:            types.ErrWriteFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		cli := CLI{
+			IdpEntryUrl:     tt.idpEntryUrl,
+			Region:          "us-east-1",
+			Duration:        900,
+			Profile:         "default",
+			RoleFilter:      "AdministratorAccess",
+			StsClient:       tt.stsClient,
+			CredentialsFile: tt.credentialsFile,
+			Logger:          logrus.New(),
+		}
+		if tt.roleFilter != "" {
+			cli.RoleFilter = tt.roleFilter
+		}
+		if cli.CredentialsFile == "" {
+			cli.CredentialsFile = filepath.Join(t.TempDir(), "credentials")
+		}
+		cli.User = types.User{Username: "potato", Password: "cheese", Domain: "domain"}
+		err := cli.Login(context.Background())
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", err, tt.want)
+		}
 	}
 }
 
@@ -150,7 +352,10 @@ func Test_STS_Credentails(t *testing.T) {
 		t.Logf("Running test -- %s", tt.name)
 		tt.input.StsClient = tt.client()
 		duration := int32(tt.input.Duration)
-		got := tt.input.getStsCredentials(duration, "")
+		got, err := tt.input.getStsCredentials(context.Background(), duration, "")
+		if err != nil {
+			t.Fatalf("Error running test -- got: %v want: %v", err, nil)
+		}
 		t.Logf("Retreived temporary STS credentials -- AccessKeyID: %v -- SecretAccessKey: %v -- SessionToken: %v -- Expiration: %v", getPointerValue(got.Credentials.AccessKeyId), getPointerValue(got.Credentials.SecretAccessKey), getPointerValue(got.Credentials.SessionToken), got.Credentials.Expiration)
 		if !reflect.DeepEqual(got.Credentials, tt.want.Credentials) {
 			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
@@ -202,3 +407,359 @@ aws_session_token=anicesessiontoken`,
 		}
 	}
 }
+
+func Test_Merge_Profiles(t *testing.T) {
+	update := "\n[prod]\nregion=us-east-1\naws_access_key_id=new"
+	tests := []struct {
+		name     string
+		existing string
+		want     string
+	}{
+		{
+			name:     "Validate a new file holds only the new profiles",
+			existing: "",
+			want:     "[prod]\nregion=us-east-1\naws_access_key_id=new\n",
+		},
+		{
+			name:     "Validate other profiles and comments are kept",
+			existing: "# my keys\n[personal]\naws_access_key_id=mine\n",
+			want:     "# my keys\n[personal]\naws_access_key_id=mine\n[prod]\nregion=us-east-1\naws_access_key_id=new\n",
+		},
+		{
+			name:     "Validate the old profile section is dropped and the update appended",
+			existing: "[prod]\naws_access_key_id=old\n\n[ personal ]\naws_access_key_id=mine\n",
+			want:     "[ personal ]\naws_access_key_id=mine\n[prod]\nregion=us-east-1\naws_access_key_id=new\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got := mergeProfiles(tt.existing, update)
+		if got != tt.want {
+			t.Errorf("Error running test -- got: %q want: %q", got, tt.want)
+		}
+	}
+}
+
+func Test_STS_Duration_Fallback(t *testing.T) {
+	accessKeyId := "someaccesskeyid"
+	secretAccessKey := "somesecretaccesskey"
+	sessionToken := "somesessiontoken"
+
+	tests := []struct {
+		name        string
+		maxDuration int32
+		input       CLI
+		duration    int32
+		want        []int32
+	}{
+		{
+			name:        "Validate the requested maximum duration is used when allowed",
+			maxDuration: 43200,
+			input:       CLI{DurationMax: true, Logger: logrus.New()},
+			duration:    28800,
+			want:        []int32{28800},
+		},
+		{
+			name:        "Validate a rejected maximum duration falls back progressively",
+			maxDuration: 3600,
+			input:       CLI{DurationMax: true, Logger: logrus.New()},
+			duration:    28800,
+			want:        []int32{28800, 14400, 3600},
+		},
+		{
+			name:        "Validate a rejected explicit duration falls back to the next allowed step",
+			maxDuration: 28800,
+			input:       CLI{Duration: 36000, Logger: logrus.New()},
+			duration:    36000,
+			want:        []int32{36000, 28800},
+		},
+		{
+			name:        "Validate the full fallback ladder is walked from twelve hours",
+			maxDuration: 7200,
+			input:       CLI{DurationMax: true, Logger: logrus.New()},
+			duration:    43200,
+			want:        []int32{43200, 28800, 14400, 3600},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		var requested []int32
+		tt.input.StsClient = mockStsClient(
+			func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+				requested = append(requested, *params.DurationSeconds)
+				if *params.DurationSeconds > tt.maxDuration {
+					return nil, &smithy.GenericAPIError{
+						Code:    "ValidationError",
+						Message: "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.",
+					}
+				}
+				return &sts.AssumeRoleWithSAMLOutput{
+					Credentials: &stsTypes.Credentials{
+						AccessKeyId:     &accessKeyId,
+						SecretAccessKey: &secretAccessKey,
+						SessionToken:    &sessionToken,
+					},
+				}, nil
+			})
+		tt.input.getStsCredentials(context.Background(), tt.duration, "")
+		if !reflect.DeepEqual(requested, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", requested, tt.want)
+		}
+	}
+}
+
+func Test_Account_Aliases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		testBody, _ := ioutil.ReadFile(testAwsSignin)
+		rw.Write(testBody)
+	}))
+	defer server.Close()
+
+	roles := []types.Role{
+		{
+			Name:         "arn:aws:iam::123456789123:role/AdministratorAccess",
+			PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+		},
+		{
+			Name:         "arn:aws:iam::987654321321:role/DeveloperAccess",
+			PrincipalArn: "arn:aws:iam::987654321321:saml-provider/ADFS",
+		},
+	}
+
+	tests := []struct {
+		name  string
+		input CLI
+		want  []string
+	}{
+		{
+			name: "Validate sign-in page aliases are preferred over the configured aliases",
+			input: CLI{
+				ResolveAliases: true,
+				AccountAliases: map[string]string{
+					"123456789123": "configured-prod",
+					"987654321321": "configured-dev",
+				},
+			},
+			want: []string{"prod-account", "configured-dev"},
+		},
+		{
+			name: "Validate configured aliases are used when resolution is disabled",
+			input: CLI{
+				AccountAliases: map[string]string{
+					"987654321321": "configured-dev",
+				},
+			},
+			want: []string{"", "configured-dev"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		tt.input.Logger = logrus.New()
+		s := saml.Saml{
+			SigninUrl: server.URL,
+			Roles:     append([]types.Role{}, roles...),
+			Logger:    tt.input.Logger,
+		}
+		tt.input.applyAccountAliases(context.Background(), &s)
+		var got []string
+		for _, role := range s.Roles {
+			got = append(got, role.AccountAlias)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Test_Keyring_Password(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fixture := testLoginSuccess
+		if req.URL.Path == "/" {
+			fixture = testLoginPage
+		}
+		testBody, _ := ioutil.ReadFile(fixture)
+		rw.Write(testBody)
+	}))
+	defer server.Close()
+	account := keyring.Account(server.URL, "domain", "potato")
+
+	tests := []struct {
+		name      string
+		env       string
+		stored    string
+		save      bool
+		want      string
+		wantSaved string
+	}{
+		{
+			name:      "Validate the keyring password is used when no other source is set",
+			stored:    "fromkeyring",
+			want:      "fromkeyring",
+			wantSaved: "fromkeyring",
+		},
+		{
+			name:      "Validate the environment takes precedence over the keyring",
+			env:       "fromenv",
+			stored:    "fromkeyring",
+			want:      "fromenv",
+			wantSaved: "fromkeyring",
+		},
+		{
+			name:      "Validate the password is saved after a successful login",
+			env:       "fromenv",
+			save:      true,
+			want:      "fromenv",
+			wantSaved: "fromenv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		if tt.env != "" {
+			t.Setenv("AWS_PASSWORD", tt.env)
+		} else {
+			os.Unsetenv("AWS_PASSWORD")
+		}
+		k := keyring.NewMemory()
+		if tt.stored != "" {
+			k.Set(account, tt.stored)
+		}
+		cli := CLI{IdpEntryUrl: server.URL, User: types.User{Username: "potato", Domain: "domain"}, Keyring: k, SavePassword: tt.save, Logger: logrus.New()}
+		s, err := cli.authenticate(context.Background())
+		if err != nil {
+			t.Errorf("Error running test -- got: %v want: %v", err, nil)
+		}
+		if s.User.Password != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", s.User.Password, tt.want)
+		}
+		if saved, _ := k.Get(account); saved != tt.wantSaved {
+			t.Errorf("Error running test -- got saved: %v want: %v", saved, tt.wantSaved)
+		}
+	}
+}
+
+func Test_Password_Input(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	os.WriteFile(passwordFile, []byte("fromfile\nignored\n"), 0600)
+	emptyFile := filepath.Join(dir, "empty")
+	os.WriteFile(emptyFile, []byte("\n"), 0600)
+
+	tests := []struct {
+		name       string
+		input      CLI
+		stdin      string
+		want       string
+		wantExists bool
+		wantErr    bool
+	}{
+		{
+			name:       "Validate the password is read from stdin up to the line break",
+			input:      CLI{PasswordStdin: true},
+			stdin:      "fromstdin\r\n",
+			want:       "fromstdin",
+			wantExists: true,
+		},
+		{
+			name:       "Validate a password without a line break is read from stdin",
+			input:      CLI{PasswordStdin: true},
+			stdin:      "fromstdin",
+			want:       "fromstdin",
+			wantExists: true,
+		},
+		{
+			name:       "Validate the password is read from the first line of a file",
+			input:      CLI{PasswordFile: passwordFile},
+			want:       "fromfile",
+			wantExists: true,
+		},
+		{
+			name:  "Validate no password is read without the options",
+			input: CLI{},
+			stdin: "fromstdin\n",
+		},
+		{
+			name:    "Validate an empty password is an error",
+			input:   CLI{PasswordFile: emptyFile},
+			wantErr: true,
+		},
+		{
+			name:    "Validate a missing file is an error",
+			input:   CLI{PasswordFile: filepath.Join(dir, "missing")},
+			wantErr: true,
+		},
+		{
+			name:    "Validate stdin and a file can't be combined",
+			input:   CLI{PasswordStdin: true, PasswordFile: passwordFile},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		stdin = strings.NewReader(tt.stdin)
+		tt.input.Logger = logrus.New()
+		got, exists, err := tt.input.passwordInput()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if got != tt.want || exists != tt.wantExists {
+			t.Errorf("Error running test -- got: %v %v want: %v %v", got, exists, tt.want, tt.wantExists)
+		}
+	}
+}
+
+func Test_Setup_Credentials_Password_Stdin(t *testing.T) {
+	os.Unsetenv("AWS_USERNAME")
+	os.Unsetenv("ADFS_DOMAIN")
+	tests := []struct {
+		name    string
+		input   CLI
+		want    types.User
+		wantErr string
+	}{
+		{
+			name:  "Validate the password is read with the username and domain set",
+			input: CLI{User: types.User{Username: "potato", Domain: "domain"}},
+			want:  types.User{Username: "potato", Password: "fromstdin", Domain: "domain"},
+		},
+		{
+			name:  "Validate the plain username format needs no domain",
+			input: CLI{User: types.User{Username: "potato"}, UsernameFormat: saml.UsernameFormatPlain},
+			want:  types.User{Username: "potato", Password: "fromstdin"},
+		},
+		{
+			name:    "Validate a missing username is an error before any prompt",
+			input:   CLI{User: types.User{Domain: "domain"}},
+			want:    types.User{Domain: "domain"},
+			wantErr: "--username",
+		},
+		{
+			name:    "Validate a missing domain is an error before any prompt",
+			input:   CLI{User: types.User{Username: "potato"}},
+			want:    types.User{Username: "potato"},
+			wantErr: "--domain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		input := strings.NewReader("fromstdin\n")
+		stdin = input
+		tt.input.PasswordStdin = true
+		tt.input.Logger = logrus.New()
+		got, err := tt.input.setupCredentials()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Error running test -- got: %v want error containing: %q", err, tt.wantErr)
+		}
+		if tt.wantErr != "" && input.Len() == 0 {
+			t.Errorf("Error running test -- the password was read from stdin")
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
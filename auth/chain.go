@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/S7R4nG3/aws-adfs-login/prompts"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// STS limits sessions from role chaining to one hour
+	maxChainDuration = 3600
+	// Session name used when neither the chain role nor the SAML assertion names one
+	defaultSessionName = "aws-adfs-login"
+)
+
+// Prompts for the MFA code of a chained role, replaced in tests.
+var mfaToken = prompts.MfaToken
+
+// A role assumed with the credentials of the previous role after the SAML login.
+type ChainRole struct {
+	RoleArn     string
+	ExternalId  string
+	MfaSerial   string
+	SessionName string
+}
+
+// Parses a --chain-role value, the role ARN optionally followed by comma separated
+// external_id, mfa_serial and session_name settings, for example
+// arn:aws:iam::123456789123:role/Workload,external_id=abc,session_name=jdoe
+func ParseChainRole(value string) (ChainRole, error) {
+	fields := strings.Split(value, ",")
+	role := ChainRole{RoleArn: strings.TrimSpace(fields[0])}
+	if !strings.HasPrefix(role.RoleArn, "arn:") || !strings.Contains(role.RoleArn, ":role/") {
+		return role, fmt.Errorf("invalid chain role %q, expected a role ARN", value)
+	}
+	for _, field := range fields[1:] {
+		key, val, found := strings.Cut(field, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !found || val == "" {
+			return role, fmt.Errorf("invalid chain role setting %q, expected key=value", field)
+		}
+		switch key {
+		case "external_id":
+			role.ExternalId = val
+		case "mfa_serial":
+			role.MfaSerial = val
+		case "session_name":
+			role.SessionName = val
+		default:
+			return role, fmt.Errorf("unknown chain role setting %q, expected external_id, mfa_serial or session_name", key)
+		}
+	}
+	return role, nil
+}
+
+// Hops from the SAML credentials through each chained role in turn, assuming every
+// role with the credentials of the previous one, and returns the final credentials.
+func (cli CLI) chainRoles(ctx context.Context, creds *sts.AssumeRoleWithSAMLOutput, duration int32, sessionName string) (*sts.AssumeRoleWithSAMLOutput, error) {
+	log := cli.Logger
+	if duration > maxChainDuration {
+		log.Infof("Limiting the chained session duration to %d seconds", maxChainDuration)
+		duration = maxChainDuration
+	}
+	for _, role := range cli.ChainRoles {
+		log.Infof("Assuming chained role %s", role.RoleArn)
+		input := sts.AssumeRoleInput{
+			RoleArn:         &role.RoleArn,
+			RoleSessionName: &sessionName,
+			DurationSeconds: &duration,
+		}
+		if role.SessionName != "" {
+			input.RoleSessionName = &role.SessionName
+		}
+		if role.ExternalId != "" {
+			input.ExternalId = &role.ExternalId
+		}
+		if role.MfaSerial != "" {
+			serial := role.MfaSerial
+			token, err := mfaToken(role.MfaSerial)
+			if err != nil {
+				return nil, err
+			}
+			input.SerialNumber = &serial
+			input.TokenCode = &token
+		}
+		previous := creds.Credentials
+		provider := credentials.NewStaticCredentialsProvider(getPointerValue(previous.AccessKeyId), getPointerValue(previous.SecretAccessKey), getPointerValue(previous.SessionToken))
+		output, err := cli.StsClient.AssumeRole(ctx, &input, func(o *sts.Options) {
+			o.Credentials = provider
+		})
+		if err != nil {
+			return nil, types.NewError(stsErrorKind(err), "assuming chained role "+role.RoleArn, err)
+		}
+		addCredentialSecrets(log, output.Credentials)
+		creds = &sts.AssumeRoleWithSAMLOutput{Credentials: output.Credentials}
+	}
+	return creds, nil
+}
+
+// The session name for chained roles, the RoleSessionName from the SAML assertion
+// when present.
+func chainSessionName(samlSessionName string) string {
+	if samlSessionName != "" {
+		return samlSessionName
+	}
+	return defaultSessionName
+}
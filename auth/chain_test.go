@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/sirupsen/logrus"
+)
+
+func Test_Parse_Chain_Role(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    ChainRole
+		wantErr bool
+	}{
+		{
+			name:  "Validate a bare role ARN",
+			input: "arn:aws:iam::123456789123:role/Workload",
+			want:  ChainRole{RoleArn: "arn:aws:iam::123456789123:role/Workload"},
+		},
+		{
+			name:  "Validate all chain role settings",
+			input: "arn:aws:iam::123456789123:role/Workload, external_id=abc123,mfa_serial=arn:aws:iam::111111111111:mfa/jdoe,session_name=jdoe",
+			want: ChainRole{
+				RoleArn:     "arn:aws:iam::123456789123:role/Workload",
+				ExternalId:  "abc123",
+				MfaSerial:   "arn:aws:iam::111111111111:mfa/jdoe",
+				SessionName: "jdoe",
+			},
+		},
+		{
+			name:    "Validate a value that isn't a role ARN is an error",
+			input:   "Workload",
+			wantErr: true,
+		},
+		{
+			name:    "Validate an unknown setting is an error",
+			input:   "arn:aws:iam::123456789123:role/Workload,duration=3600",
+			wantErr: true,
+		},
+		{
+			name:    "Validate a setting without a value is an error",
+			input:   "arn:aws:iam::123456789123:role/Workload,external_id",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got, err := ParseChainRole(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Test_Chain_Roles(t *testing.T) {
+	mfaToken = func(serial string) (string, error) { return "123456", nil }
+	samlKey, samlSecret, samlToken := "samlaccesskeyid", "samlsecretaccesskey", "samlsessiontoken"
+	samlCreds := &sts.AssumeRoleWithSAMLOutput{
+		Credentials: &stsTypes.Credentials{
+			AccessKeyId:     &samlKey,
+			SecretAccessKey: &samlSecret,
+			SessionToken:    &samlToken,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		roles    []ChainRole
+		duration int32
+		deny     string
+		want     []string
+		wantKey  string
+		wantErr  bool
+	}{
+		{
+			name:     "Validate each hop uses the credentials of the previous role",
+			roles:    []ChainRole{{RoleArn: "arn:aws:iam::111111111111:role/Hub"}, {RoleArn: "arn:aws:iam::222222222222:role/Workload", SessionName: "jdoe"}},
+			duration: 900,
+			want: []string{
+				"arn:aws:iam::111111111111:role/Hub session=saml-user duration=900 external= mfa=/ caller=samlaccesskeyid",
+				"arn:aws:iam::222222222222:role/Workload session=jdoe duration=900 external= mfa=/ caller=arn:aws:iam::111111111111:role/Hub-key",
+			},
+			wantKey: "arn:aws:iam::222222222222:role/Workload-key",
+		},
+		{
+			name:     "Validate the external ID and MFA code are sent and the duration is limited",
+			roles:    []ChainRole{{RoleArn: "arn:aws:iam::222222222222:role/Workload", ExternalId: "abc123", MfaSerial: "arn:aws:iam::111111111111:mfa/jdoe"}},
+			duration: 43200,
+			want: []string{
+				"arn:aws:iam::222222222222:role/Workload session=saml-user duration=3600 external=abc123 mfa=arn:aws:iam::111111111111:mfa/jdoe/123456 caller=samlaccesskeyid",
+			},
+			wantKey: "arn:aws:iam::222222222222:role/Workload-key",
+		},
+		{
+			name:     "Validate a denied hop stops the chain",
+			roles:    []ChainRole{{RoleArn: "arn:aws:iam::111111111111:role/Hub"}, {RoleArn: "arn:aws:iam::222222222222:role/Workload"}},
+			duration: 900,
+			deny:     "arn:aws:iam::111111111111:role/Hub",
+			want: []string{
+				"arn:aws:iam::111111111111:role/Hub session=saml-user duration=900 external= mfa=/ caller=samlaccesskeyid",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		var got []string
+		cli := CLI{ChainRoles: tt.roles, Logger: logrus.New()}
+		cli.StsClient = mockChainClient{
+			assumeRole: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				options := sts.Options{}
+				for _, fn := range optFns {
+					fn(&options)
+				}
+				caller, _ := options.Credentials.Retrieve(ctx)
+				got = append(got, fmt.Sprintf("%s session=%s duration=%d external=%s mfa=%s/%s caller=%s", *params.RoleArn, *params.RoleSessionName, *params.DurationSeconds, getPointerValue(params.ExternalId), getPointerValue(params.SerialNumber), getPointerValue(params.TokenCode), caller.AccessKeyID))
+				if *params.RoleArn == tt.deny {
+					return nil, errors.New("access denied")
+				}
+				key, secret, token := *params.RoleArn+"-key", *params.RoleArn+"-secret", *params.RoleArn+"-token"
+				return &sts.AssumeRoleOutput{
+					Credentials: &stsTypes.Credentials{
+						AccessKeyId:     &key,
+						SecretAccessKey: &secret,
+						SessionToken:    &token,
+					},
+				}, nil
+			},
+		}
+		creds, err := cli.chainRoles(context.Background(), samlCreds, tt.duration, chainSessionName("saml-user"))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+		if !tt.wantErr && getPointerValue(creds.Credentials.AccessKeyId) != tt.wantKey {
+			t.Errorf("Error running test -- got: %v want: %v", getPointerValue(creds.Credentials.AccessKeyId), tt.wantKey)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const (
+	// How long before the credentials expire the daemon refreshes them
+	DefaultRefreshWindow = 5 * time.Minute
+	// How long the daemon waits before retrying a refresh that failed to reach ADFS or AWS
+	retryDelay = time.Minute
+	// The longest wait before retrying a refresh that failed otherwise
+	maxRetryDelay = time.Hour
+	// The shortest wait between two refreshes
+	minRefreshDelay = 30 * time.Second
+	// How long a control connection may take to send its command or read the reply
+	defaultControlTimeout = 10 * time.Second
+)
+
+// The commands accepted on the daemon control socket
+const (
+	DaemonStatus  = "status"
+	DaemonRefresh = "refresh"
+)
+
+// The outcome of the last refresh of a profile.
+type ProfileStatus struct {
+	Profile    string    `json:"profile"`
+	Role       string    `json:"role"`
+	Expiration time.Time `json:"expiration,omitempty"`
+	Refreshed  time.Time `json:"refreshed,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// The reply to a control socket command.
+type DaemonResponse struct {
+	Profiles    []ProfileStatus `json:"profiles"`
+	NextRefresh time.Time       `json:"next_refresh"`
+	// The failure of the last refresh, or of the command
+	Error string `json:"error,omitempty"`
+}
+
+// Keeps the credentials of the configured profiles fresh, signing in again with
+// the password held in memory and rewriting the credentials file shortly before
+// the credentials expire.
+type Daemon struct {
+	CLI           CLI
+	Profiles      []config.Profile
+	RefreshWindow time.Duration
+	// The clock and control connection timeout, replaced in tests
+	now            func() time.Time
+	controlTimeout time.Duration
+	requests       chan chan error
+	mu             sync.Mutex
+	status         []ProfileStatus
+	next           time.Time
+	lastErr        error
+	// Closed once Run returns, with the error it returned in stopErr
+	stopped chan struct{}
+	stopErr error
+	// The credentials written for each profile, dropped from the redacted secrets
+	// once replaced
+	written map[string]*stsTypes.Credentials
+	// The refreshes in a row that failed other than with a network error
+	failures int
+}
+
+// Creates a daemon refreshing the profiles with the DefaultRefreshWindow.
+func NewDaemon(cli CLI, profiles []config.Profile) *Daemon {
+	return &Daemon{
+		CLI:            cli,
+		Profiles:       profiles,
+		RefreshWindow:  DefaultRefreshWindow,
+		now:            time.Now,
+		controlTimeout: defaultControlTimeout,
+		requests:       make(chan chan error),
+		stopped:        make(chan struct{}),
+	}
+}
+
+// Resolves the login password once, then refreshes the profiles until the context
+// is cancelled. Refreshes that fail are retried, except when the IdP rejects the
+// login as retrying could lock the account.
+func (d *Daemon) Run(ctx context.Context) (err error) {
+	defer func() {
+		d.mu.Lock()
+		d.stopErr = err
+		d.mu.Unlock()
+		close(d.stopped)
+	}()
+	user, err := d.CLI.setupCredentials()
+	if err != nil {
+		return err
+	}
+	d.CLI.User = user
+	if d.CLI.StsClient == nil {
+		if d.CLI.StsClient, err = NewStsClient(ctx, d.CLI.Region); err != nil {
+			return err
+		}
+	}
+	var reply chan error
+	for {
+		err := d.refresh(ctx)
+		if reply != nil {
+			reply <- err
+			reply = nil
+		}
+		if errors.Is(err, types.ErrAuthFailed) {
+			return err
+		}
+		timer := time.NewTimer(d.nextRefresh().Sub(d.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		case reply = <-d.requests:
+			timer.Stop()
+		}
+	}
+}
+
+// Asks Run to refresh the profiles now and waits for the outcome. Once Run has
+// returned the error it stopped with is returned instead.
+func (d *Daemon) Refresh(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case d.requests <- reply:
+	case <-d.stopped:
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.stopErr != nil {
+			return fmt.Errorf("the daemon has stopped: %w", d.stopErr)
+		}
+		return errors.New("the daemon has stopped")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The outcome of the last refresh of each profile and when the next is due.
+func (d *Daemon) Status() DaemonResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	response := DaemonResponse{
+		Profiles:    append([]ProfileStatus{}, d.status...),
+		NextRefresh: d.next,
+	}
+	if d.lastErr != nil {
+		response.Error = d.lastErr.Error()
+	}
+	return response
+}
+
+// Answers the commands sent to the control socket until the context is cancelled,
+// one command per connection.
+func (d *Daemon) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accepting daemon control connection: %w", err)
+		}
+		go d.handle(ctx, conn)
+	}
+}
+
+func (d *Daemon) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(d.controlTimeout))
+	command, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	var commandErr error
+	switch strings.TrimSpace(command) {
+	case DaemonStatus:
+	case DaemonRefresh:
+		commandErr = d.Refresh(ctx)
+	default:
+		commandErr = fmt.Errorf("unknown command %q, expected %s or %s", strings.TrimSpace(command), DaemonStatus, DaemonRefresh)
+	}
+	response := d.Status()
+	if commandErr != nil {
+		response.Error = commandErr.Error()
+	}
+	conn.SetWriteDeadline(time.Now().Add(d.controlTimeout))
+	json.NewEncoder(conn).Encode(response)
+}
+
+// Signs in and rewrites the credentials of every profile, recording the outcome
+// and when the next refresh is due.
+func (d *Daemon) refresh(ctx context.Context) error {
+	log := d.CLI.Logger
+	log.Info("Refreshing the profile credentials...")
+	results, err := d.CLI.refreshProfiles(ctx, d.Profiles)
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastErr = err
+	if results == nil {
+		d.next = now.Add(d.retryDelay(err))
+		log.Warnf("Unable to refresh the profiles, retrying at %s -- %v", d.next.Format(time.RFC1123), err)
+		return err
+	}
+	d.forgetCredentials(results)
+	d.status = make([]ProfileStatus, len(results))
+	var earliest time.Time
+	var failed error
+	for i, result := range results {
+		status := ProfileStatus{Profile: result.Profile, Role: result.Role.Name}
+		if result.Err != nil {
+			status.Error = result.Err.Error()
+			if failed == nil || errors.Is(result.Err, types.ErrNetwork) {
+				failed = result.Err
+			}
+		} else {
+			status.Refreshed = now
+			if expiration := result.Credentials.Credentials.Expiration; expiration != nil {
+				status.Expiration = *expiration
+				if earliest.IsZero() || expiration.Before(earliest) {
+					earliest = *expiration
+				}
+			}
+		}
+		d.status[i] = status
+	}
+	if failed == nil {
+		// Every role was assumed but the credentials file couldn't be written
+		failed = err
+	}
+	d.next = time.Time{}
+	if !earliest.IsZero() {
+		d.next = earliest.Add(-d.RefreshWindow)
+		if min := now.Add(minRefreshDelay); d.next.Before(min) {
+			d.next = min
+		}
+	}
+	if failed != nil {
+		if retry := now.Add(d.retryDelay(failed)); d.next.IsZero() || retry.Before(d.next) {
+			d.next = retry
+		}
+	} else {
+		d.failures = 0
+	}
+	if d.next.IsZero() {
+		d.next = now.Add(retryDelay)
+	}
+	log.Infof("Next refresh at %s", d.next.Format(time.RFC1123))
+	return err
+}
+
+// The wait before retrying after the failure. Network errors are usually brief
+// and retried shortly, other failures such as a role that isn't granted won't be
+// fixed by signing in again, so the wait doubles with each refresh in a row that
+// fails up to maxRetryDelay.
+func (d *Daemon) retryDelay(err error) time.Duration {
+	if errors.Is(err, types.ErrNetwork) {
+		return retryDelay
+	}
+	d.failures++
+	delay := retryDelay
+	for i := 1; i < d.failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// Stops redacting the credentials of the previous refresh that the results replaced.
+func (d *Daemon) forgetCredentials(results []loginResult) {
+	if d.written == nil {
+		d.written = map[string]*stsTypes.Credentials{}
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		removeCredentialSecrets(d.CLI.Logger, d.written[result.Profile], result.Credentials.Credentials)
+		d.written[result.Profile] = result.Credentials.Credentials
+	}
+}
+
+func (d *Daemon) nextRefresh() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.next
+}
+
+// Listens on the control socket, replacing a socket left behind by a daemon that
+// is no longer running. Only the current user can connect, so the socket directory,
+// created when missing, must not be accessible to other users.
+func ListenDaemonSocket(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating the daemon socket directory: %w", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("checking the daemon socket directory: %w", err)
+	}
+	// Windows doesn't report access for other users in the file mode
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("listening on %s: %s is accessible to other users, restrict it with chmod 700", path, dir)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("starting the daemon: another daemon is listening on %s", path)
+	}
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("restricting access to %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+// Sends a command to the daemon listening on the control socket and returns its reply.
+func DaemonRequest(ctx context.Context, path string, command string) (DaemonResponse, error) {
+	var response DaemonResponse
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return response, fmt.Errorf("connecting to the daemon at %s: %w", path, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return response, fmt.Errorf("sending the %s command to the daemon: %w", command, err)
+	}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return response, fmt.Errorf("reading the daemon reply: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/logging"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+	"github.com/sirupsen/logrus"
+)
+
+var daemonProfiles = []config.Profile{
+	{Name: "prod", Settings: config.Settings{RoleArn: "arn:aws:iam::123456789123:role/AdministratorAccess"}},
+	{Name: "dev", Settings: config.Settings{RoleArn: "arn:aws:iam::987654321321:role/DeveloperAccess"}},
+}
+
+// Serves the login page on / and the SAML response on every other path,
+// counting the logins submitted.
+func newDaemonPortal(t *testing.T, logins *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fixture := testLoginSuccess
+		if req.URL.Path == "/" {
+			fixture = testLoginPage
+		} else {
+			atomic.AddInt32(logins, 1)
+		}
+		testBody, _ := ioutil.ReadFile(fixture)
+		rw.Write(testBody)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newDaemonCli(t *testing.T, idpEntryUrl string, stsClient StsApi) CLI {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return CLI{
+		IdpEntryUrl:     idpEntryUrl,
+		Region:          "us-east-1",
+		Duration:        900,
+		CredentialsFile: filepath.Join(t.TempDir(), "credentials"),
+		User:            types.User{Username: "potato", Password: "cheese", Domain: "domain"},
+		StsClient:       stsClient,
+		Logger:          logger,
+	}
+}
+
+// Grants every role with credentials expiring after the lifetime, denying the roles in denied.
+func expiringSts(now func() time.Time, lifetime time.Duration, denied string) mockStsClient {
+	return func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		if denied != "" && *params.RoleArn == denied {
+			return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Not authorized to perform sts:AssumeRoleWithSAML"}
+		}
+		return &sts.AssumeRoleWithSAMLOutput{
+			Credentials: &stsTypes.Credentials{
+				AccessKeyId:     aws.String("someaccesskeyid"),
+				SecretAccessKey: aws.String("somesecretaccesskey"),
+				SessionToken:    aws.String("somesessiontoken"),
+				Expiration:      aws.Time(now().Add(lifetime)),
+			},
+		}, nil
+	}
+}
+
+func Test_Daemon_Refresh(t *testing.T) {
+	var logins int32
+	server := newDaemonPortal(t, &logins)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	now := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	tests := []struct {
+		name        string
+		idpEntryUrl string
+		lifetime    time.Duration
+		denied      string
+		wantNext    time.Duration
+		wantErrors  int
+		wantErr     error
+	}{
+		{
+			name:        "Refresh is due before the credentials expire...",
+			idpEntryUrl: server.URL,
+			lifetime:    time.Hour,
+			wantNext:    55 * time.Minute,
+		},
+		{
+			name:        "Short lived credentials wait the minimum delay...",
+			idpEntryUrl: server.URL,
+			lifetime:    2 * time.Minute,
+			wantNext:    minRefreshDelay,
+		},
+		{
+			name:        "Failed profile is retried shortly...",
+			idpEntryUrl: server.URL,
+			lifetime:    time.Hour,
+			denied:      "arn:aws:iam::987654321321:role/DeveloperAccess",
+			wantNext:    retryDelay,
+			wantErrors:  1,
+			wantErr:     types.ErrStsDenied,
+		},
+		{
+			name:        "Unreachable portal is retried shortly...",
+			idpEntryUrl: closed.URL,
+			lifetime:    time.Hour,
+			wantNext:    retryDelay,
+			wantErr:     types.ErrNetwork,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		cli := newDaemonCli(t, tt.idpEntryUrl, expiringSts(clock, tt.lifetime, tt.denied))
+		os.WriteFile(cli.CredentialsFile, []byte("[personal]\naws_access_key_id=mine\n"), 0600)
+		daemon := NewDaemon(cli, daemonProfiles)
+		daemon.now = clock
+		err := daemon.refresh(context.Background())
+		if content, _ := os.ReadFile(cli.CredentialsFile); !strings.Contains(string(content), "[personal]\naws_access_key_id=mine") {
+			t.Errorf("Error running test -- got: %v want: the personal profile kept", string(content))
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Error running test -- got: %v want: %v", err, tt.wantErr)
+		}
+		status := daemon.Status()
+		if got := status.NextRefresh.Sub(now); got != tt.wantNext {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.wantNext)
+		}
+		errs := 0
+		for _, profile := range status.Profiles {
+			if profile.Error != "" {
+				errs++
+			} else if !profile.Expiration.Equal(now.Add(tt.lifetime)) {
+				t.Errorf("Error running test -- got: %v want: %v", profile.Expiration, now.Add(tt.lifetime))
+			}
+		}
+		if errs != tt.wantErrors {
+			t.Errorf("Error running test -- got: %v failed profiles want: %v", errs, tt.wantErrors)
+		}
+	}
+}
+
+func Test_Daemon_Backoff(t *testing.T) {
+	var logins int32
+	server := newDaemonPortal(t, &logins)
+	now := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	denied := expiringSts(clock, time.Hour, daemonProfiles[1].RoleArn)
+	unreachable := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		return nil, errors.New("connection reset")
+	})
+	daemon := NewDaemon(newDaemonCli(t, server.URL, denied), daemonProfiles)
+	daemon.now = clock
+
+	tests := []struct {
+		name      string
+		stsClient StsApi
+		wantNext  time.Duration
+	}{
+		{
+			name:      "First denied role is retried after the retry delay...",
+			stsClient: denied,
+			wantNext:  retryDelay,
+		},
+		{
+			name:      "Denied role again doubles the wait...",
+			stsClient: denied,
+			wantNext:  2 * retryDelay,
+		},
+		{
+			name:      "Denied role a third time doubles the wait again...",
+			stsClient: denied,
+			wantNext:  4 * retryDelay,
+		},
+		{
+			name:      "Network errors are retried after the retry delay...",
+			stsClient: unreachable,
+			wantNext:  retryDelay,
+		},
+		{
+			name:      "Success resets the backoff to the expiry schedule...",
+			stsClient: expiringSts(clock, time.Hour, ""),
+			wantNext:  55 * time.Minute,
+		},
+		{
+			name:      "Denied role after a success starts over...",
+			stsClient: denied,
+			wantNext:  retryDelay,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		daemon.CLI.StsClient = tt.stsClient
+		daemon.refresh(context.Background())
+		if got := daemon.Status().NextRefresh.Sub(now); got != tt.wantNext {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.wantNext)
+		}
+	}
+}
+
+func Test_Daemon_Control(t *testing.T) {
+	var logins int32
+	server := newDaemonPortal(t, &logins)
+	cli := newDaemonCli(t, server.URL, expiringSts(time.Now, time.Hour, ""))
+	daemon := NewDaemon(cli, daemonProfiles)
+
+	dir, _ := os.MkdirTemp("", "daemon")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "daemon.sock")
+	listener, err := ListenDaemonSocket(socket)
+	if err != nil {
+		t.Fatalf("Error listening on the socket -- %v", err)
+	}
+	if _, err := ListenDaemonSocket(socket); err == nil {
+		t.Errorf("Error running test -- got: no error want: a daemon is already listening")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	ran := make(chan error)
+	go func() { served <- daemon.Serve(ctx, listener) }()
+	go func() { ran <- daemon.Run(ctx) }()
+
+	tests := []struct {
+		name       string
+		command    string
+		wantLogins int32
+		wantErr    string
+	}{
+		{
+			name:       "Status reports the refreshed profiles...",
+			command:    DaemonStatus,
+			wantLogins: 1,
+		},
+		{
+			name:       "Refresh signs in again...",
+			command:    DaemonRefresh,
+			wantLogins: 2,
+		},
+		{
+			name:       "Unknown command is reported...",
+			command:    "restart",
+			wantLogins: 2,
+			wantErr:    "unknown command",
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		// The first refresh runs as the daemon starts
+		for i := 0; i < 100 && atomic.LoadInt32(&logins) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		response, err := DaemonRequest(ctx, socket, tt.command)
+		if err != nil {
+			t.Fatalf("Error sending the command -- %v", err)
+		}
+		if !strings.Contains(response.Error, tt.wantErr) || (tt.wantErr == "" && response.Error != "") {
+			t.Errorf("Error running test -- got: %v want: %v", response.Error, tt.wantErr)
+		}
+		if got := atomic.LoadInt32(&logins); got != tt.wantLogins {
+			t.Errorf("Error running test -- got: %v logins want: %v", got, tt.wantLogins)
+		}
+		if len(response.Profiles) != len(daemonProfiles) || response.Profiles[0].Expiration.IsZero() {
+			t.Errorf("Error running test -- got: %+v want: %d refreshed profiles", response.Profiles, len(daemonProfiles))
+		}
+	}
+
+	cancel()
+	if err := <-ran; err != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, nil)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, nil)
+	}
+}
+
+func Test_Daemon_Auth_Failed(t *testing.T) {
+	rejecting := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		testBody, _ := ioutil.ReadFile(testLoginPage)
+		rw.Write(testBody)
+	}))
+	defer rejecting.Close()
+
+	daemon := NewDaemon(newDaemonCli(t, rejecting.URL, expiringSts(time.Now, time.Hour, "")), daemonProfiles)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := daemon.Run(ctx); !errors.Is(err, types.ErrAuthFailed) {
+		t.Errorf("Error running test -- got: %v want: %v", err, types.ErrAuthFailed)
+	}
+	// A refresh once the daemon has stopped returns why instead of waiting
+	if err := daemon.Refresh(ctx); !errors.Is(err, types.ErrAuthFailed) || ctx.Err() != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, types.ErrAuthFailed)
+	}
+}
+
+func Test_Daemon_Forgets_Replaced_Credentials(t *testing.T) {
+	var logins, calls int32
+	server := newDaemonPortal(t, &logins)
+	numbered := numberedSts(&calls)
+	var output bytes.Buffer
+	cli := newDaemonCli(t, server.URL, numbered)
+	cli.Logger = logging.New()
+	cli.Logger.SetOutput(&output)
+	cli.Parallelism = 1
+	daemon := NewDaemon(cli, daemonProfiles[:1])
+
+	daemon.refresh(context.Background())
+	daemon.refresh(context.Background())
+	output.Reset()
+	cli.Logger.Info("sessiontoken-1 sessiontoken-2")
+	if got := output.String(); !strings.Contains(got, "sessiontoken-1 [REDACTED]") {
+		t.Errorf("Error running test -- got: %v want: %v", got, "sessiontoken-1 [REDACTED]")
+	}
+}
+
+// Grants every role with new credentials numbered by the call.
+func numberedSts(calls *int32) mockStsClient {
+	return func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		n := atomic.AddInt32(calls, 1)
+		return &sts.AssumeRoleWithSAMLOutput{
+			Credentials: &stsTypes.Credentials{
+				AccessKeyId:     aws.String(fmt.Sprintf("accesskeyid-%d", n)),
+				SecretAccessKey: aws.String(fmt.Sprintf("secretaccesskey-%d", n)),
+				SessionToken:    aws.String(fmt.Sprintf("sessiontoken-%d", n)),
+				Expiration:      aws.Time(time.Now().Add(time.Hour)),
+			},
+		}, nil
+	}
+}
+
+func Test_Listen_Daemon_Socket(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "daemon")
+	defer os.RemoveAll(dir)
+	open := filepath.Join(dir, "open")
+	os.Mkdir(open, 0755)
+	os.Chmod(open, 0755)
+	private := filepath.Join(dir, "private")
+	stale := filepath.Join(dir, "stale")
+	os.Mkdir(stale, 0700)
+	os.WriteFile(filepath.Join(stale, "daemon.sock"), nil, 0600)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{
+			name: "Missing directory is created privately...",
+			path: filepath.Join(private, "daemon.sock"),
+		},
+		{
+			name: "Stale socket is replaced...",
+			path: filepath.Join(stale, "daemon.sock"),
+		},
+		{
+			name: "Private subdirectory of a directory open to other users is created...",
+			path: filepath.Join(open, "run", "daemon.sock"),
+		},
+		{
+			name:    "Directory open to other users is refused...",
+			path:    filepath.Join(open, "daemon.sock"),
+			wantErr: "accessible to other users",
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		listener, err := ListenDaemonSocket(tt.path)
+		if err == nil {
+			info, _ := os.Stat(tt.path)
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("Error running test -- got: %v want: %v", info.Mode().Perm(), os.FileMode(0600))
+			}
+			listener.Close()
+		}
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Error running test -- got: %v want: %v", err, tt.wantErr)
+		}
+	}
+}
+
+func Test_Daemon_Idle_Connection(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "daemon")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "daemon.sock")
+	listener, err := ListenDaemonSocket(socket)
+	if err != nil {
+		t.Fatalf("Error listening on the socket -- %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	daemon := NewDaemon(CLI{}, nil)
+	daemon.controlTimeout = 50 * time.Millisecond
+	go daemon.Serve(ctx, listener)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Error connecting -- %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// The daemon closes the connection once the command deadline passes
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Error running test -- got: %v want: %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/prompts"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// The number of concurrent STS calls used when --parallel is not set.
+const defaultParallelism = 4
+
+// A role to assume into a profile and the outcome. The duration is the one
+// requested until the role is assumed, then the one granted.
+type loginResult struct {
+	Profile     string
+	Role        types.Role
+	Region      string
+	Credentials *sts.AssumeRoleWithSAMLOutput
+	Duration    int32
+	Err         error
+}
+
+// Logs into several roles with the same SAML assertion, either from the --role-map
+// or from the interactive multi-select prompt.
+func (cli CLI) loginMultiple(ctx context.Context, roles []types.Role, duration int32, samlAssertion string) error {
+	targets, err := cli.loginTargets(roles)
+	if err != nil {
+		return fmt.Errorf("selecting AWS roles: %w", err)
+	}
+	for i := range targets {
+		targets[i].Region = cli.Region
+		targets[i].Duration = duration
+	}
+	return cli.writeResults(cli.assumeRoles(ctx, targets, samlAssertion))
+}
+
+// Refreshes every configured profile with a single ADFS login, the profiles
+// without a region or duration use the --region and --duration.
+func (cli CLI) LoginAll(ctx context.Context, profiles []config.Profile) error {
+	log := cli.Logger
+	fmt.Println(types.Header)
+	if _, err := cli.refreshProfiles(ctx, profiles); err != nil {
+		return err
+	}
+	log.Info("Login Complete!")
+	return nil
+}
+
+// Signs in once, assumes the role of every profile and writes the credentials of
+// those that succeeded, returning the outcome of each profile.
+func (cli CLI) refreshProfiles(ctx context.Context, profiles []config.Profile) ([]loginResult, error) {
+	saml, err := cli.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	duration := cli.sessionDuration(saml)
+	if cli.StsClient == nil {
+		if cli.StsClient, err = NewStsClient(ctx, cli.Region); err != nil {
+			return nil, err
+		}
+	}
+	targets := profileTargets(saml.Roles, profiles, cli.Region, duration)
+	results := cli.assumeRoles(ctx, targets, saml.Assertion)
+	return results, cli.writeResults(results)
+}
+
+// Matches each configured profile to its role in the SAML assertion, profiles
+// whose role isn't granted are reported as failed results.
+func profileTargets(roles []types.Role, profiles []config.Profile, region string, duration int32) []loginResult {
+	targets := make([]loginResult, len(profiles))
+	for i, profile := range profiles {
+		targets[i] = resolveTarget(roles, profile.RoleArn, "", profile.Name)
+		if profile.RoleArn == "" {
+			targets[i].Err = fmt.Errorf("profile %s has no role_arn", profile.Name)
+		}
+		targets[i].Region = region
+		if profile.Region != "" {
+			targets[i].Region = profile.Region
+		}
+		targets[i].Duration = duration
+		if profile.Duration > 0 {
+			targets[i].Duration = int32(profile.Duration)
+		}
+	}
+	return targets
+}
+
+// Prints the outcome of each role, writes a profile for each role that succeeded
+// and then fails when any role failed, with the kind of the first failure.
+func (cli CLI) writeResults(results []loginResult) error {
+	printResults(os.Stdout, results)
+	var content strings.Builder
+	failed := 0
+	var kind error
+	for _, result := range results {
+		if result.Err != nil {
+			var loginErr *types.LoginError
+			if kind == nil && errors.As(result.Err, &loginErr) {
+				kind = loginErr.Kind
+			}
+			failed++
+			continue
+		}
+		content.WriteString(writeCredentials(*result.Credentials, result.Duration, result.Profile, result.Region))
+	}
+	if content.Len() > 0 {
+		if err := cli.writeCredentialsFile(content.String()); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d roles failed", failed, len(results))
+		if kind == nil {
+			return fmt.Errorf("logging into AWS roles: %w", err)
+		}
+		return types.NewError(kind, "logging into AWS roles", err)
+	}
+	return nil
+}
+
+// Resolves the roles and profile names to log into. Each --role-map entry must match
+// exactly one role, entries that don't are reported as failed results rather than
+// stopping the other logins.
+func (cli CLI) loginTargets(roles []types.Role) ([]loginResult, error) {
+	if len(roles) == 0 {
+		return nil, types.NewError(types.ErrNoRoles, "no AWS roles found in the SAML assertion", nil)
+	}
+	var targets []loginResult
+	if len(cli.RoleMap) > 0 {
+		selectors := make([]string, 0, len(cli.RoleMap))
+		for selector := range cli.RoleMap {
+			selectors = append(selectors, selector)
+		}
+		sort.Slice(selectors, func(i, j int) bool {
+			return cli.RoleMap[selectors[i]] < cli.RoleMap[selectors[j]]
+		})
+		for _, selector := range selectors {
+			targets = append(targets, resolveTarget(roles, selector, cli.AccountFilter, cli.RoleMap[selector]))
+		}
+	} else {
+		candidates := filterRoles(roles, cli.RoleFilter, cli.AccountFilter)
+		if len(candidates) == 0 {
+			return nil, types.NewError(types.ErrNoRoles, fmt.Sprintf("no role matches %s, available roles:\n%s", describeFilters(cli.RoleFilter, cli.AccountFilter), listRoles(roles)), nil)
+		}
+		selected, err := prompts.RoleMultiSelect(candidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range selected {
+			profile, err := prompts.Profile(role.RoleName(), defaultProfile(role))
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, loginResult{Profile: profile, Role: role})
+		}
+	}
+
+	profiles := map[string]bool{}
+	for _, target := range targets {
+		if profiles[target.Profile] {
+			return nil, fmt.Errorf("profile %s is used for more than one role", target.Profile)
+		}
+		profiles[target.Profile] = true
+	}
+	return targets, nil
+}
+
+// Matches the role selector against the roles, it must match exactly one role.
+func resolveTarget(roles []types.Role, selector string, account string, profile string) loginResult {
+	target := loginResult{Profile: profile, Role: types.Role{Name: selector}}
+	matches := filterRoles(roles, selector, account)
+	switch len(matches) {
+	case 1:
+		target.Role = matches[0]
+	case 0:
+		target.Err = types.NewError(types.ErrNoRoles, "no role matches "+describeFilters(selector, account), nil)
+	default:
+		target.Err = fmt.Errorf("%s matches %d roles", describeFilters(selector, account), len(matches))
+	}
+	return target
+}
+
+// Assumes each target role concurrently, running at most --parallel STS calls at once.
+// The results keep the order of the targets.
+func (cli CLI) assumeRoles(ctx context.Context, targets []loginResult, samlAssertion string) []loginResult {
+	log := cli.Logger
+	parallelism := cli.Parallelism
+	if parallelism < 1 {
+		parallelism = defaultParallelism
+	}
+	results := append([]loginResult{}, targets...)
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(result *loginResult) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			log.Infof("Assuming role %s for profile %s", result.Role.Name, result.Profile)
+			result.Credentials, result.Duration, result.Err = cli.RoleCredentials(ctx, result.Role, result.Duration, samlAssertion)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// Prints a table with the outcome of each role login.
+func printResults(out io.Writer, results []loginResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tROLE\tRESULT")
+	for _, result := range results {
+		status := "ok"
+		switch {
+		case result.Err != nil:
+			status = "failed: " + result.Err.Error()
+		case result.Credentials.Credentials != nil && result.Credentials.Credentials.Expiration != nil:
+			status = "ok, expires " + result.Credentials.Credentials.Expiration.Local().Format(time.RFC1123)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Profile, result.Role.Name, status)
+	}
+	w.Flush()
+}
+
+// The profile name suggested for a role, its account alias or ID and the role name.
+func defaultProfile(role types.Role) string {
+	account := role.AccountAlias
+	if account == "" {
+		account = role.AccountId()
+	}
+	return strings.ToLower(account + "-" + role.RoleName())
+}
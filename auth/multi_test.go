@@ -0,0 +1,352 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/sirupsen/logrus"
+)
+
+var multiRoles = []types.Role{
+	{
+		Name:         "arn:aws:iam::111111111111:role/AdministratorAccess",
+		PrincipalArn: "arn:aws:iam::111111111111:saml-provider/ADFS",
+		AccountAlias: "dev",
+	},
+	{
+		Name:         "arn:aws:iam::222222222222:role/AdministratorAccess",
+		PrincipalArn: "arn:aws:iam::222222222222:saml-provider/ADFS",
+		AccountAlias: "staging",
+	},
+	{
+		Name:         "arn:aws:iam::333333333333:role/ReadOnly",
+		PrincipalArn: "arn:aws:iam::333333333333:saml-provider/ADFS",
+		AccountAlias: "prod",
+	},
+}
+
+func Test_Login_Targets(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    CLI
+		want     []string
+		wantErrs []string
+		wantErr  string
+	}{
+		{
+			name: "Validate the role map resolves each role to its profile",
+			input: CLI{RoleMap: map[string]string{
+				"arn:aws:iam::111111111111:role/AdministratorAccess": "dev",
+				"arn:aws:iam::222222222222:role/*":                   "staging",
+				"ReadOnly":                                           "prod",
+			}},
+			want:     []string{"dev=arn:aws:iam::111111111111:role/AdministratorAccess", "prod=arn:aws:iam::333333333333:role/ReadOnly", "staging=arn:aws:iam::222222222222:role/AdministratorAccess"},
+			wantErrs: []string{"", "", ""},
+		},
+		{
+			name: "Validate missing and ambiguous roles are reported per profile",
+			input: CLI{RoleMap: map[string]string{
+				"AdministratorAccess": "admin",
+				"PowerUser":           "power",
+			}},
+			want:     []string{"admin=AdministratorAccess", "power=PowerUser"},
+			wantErrs: []string{"--role AdministratorAccess matches 2 roles", "no role matches --role PowerUser"},
+		},
+		{
+			name: "Validate a profile can only be written once",
+			input: CLI{RoleMap: map[string]string{
+				"ReadOnly": "shared",
+				"arn:aws:iam::111111111111:role/AdministratorAccess": "shared",
+			}},
+			wantErr: "profile shared is used for more than one role",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		tt.input.Logger = logrus.New()
+		targets, err := tt.input.loginTargets(multiRoles)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Error running test -- got: %v want: %v", err, tt.wantErr)
+			}
+			continue
+		}
+		var got, gotErrs []string
+		for _, target := range targets {
+			got = append(got, target.Profile+"="+target.Role.Name)
+			errStr := ""
+			if target.Err != nil {
+				errStr = target.Err.Error()
+			}
+			gotErrs = append(gotErrs, errStr)
+		}
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(gotErrs, tt.wantErrs) {
+			t.Errorf("Error running test -- got: %v %v want: %v %v", got, gotErrs, tt.want, tt.wantErrs)
+		}
+	}
+}
+
+func Test_Assume_Roles(t *testing.T) {
+	accessKeyId := "someaccesskeyid"
+	secretAccessKey := "somesecretaccesskey"
+	sessionToken := "somesessiontoken"
+
+	tests := []struct {
+		name        string
+		parallelism int
+		targets     []loginResult
+		want        []string
+	}{
+		{
+			name:        "Validate every role is assumed and failures are kept per role",
+			parallelism: 2,
+			targets: []loginResult{
+				{Profile: "dev", Role: multiRoles[0]},
+				{Profile: "staging", Role: multiRoles[1]},
+				{Profile: "prod", Role: multiRoles[2]},
+				{Profile: "missing", Role: types.Role{Name: "PowerUser"}, Err: errors.New("no role matches --role PowerUser")},
+			},
+			want: []string{"ok", "ok", "failed: access denied", "failed: no role matches --role PowerUser"},
+		},
+		{
+			name:        "Validate a single STS call at a time is allowed",
+			parallelism: 1,
+			targets: []loginResult{
+				{Profile: "dev", Role: multiRoles[0]},
+				{Profile: "staging", Role: multiRoles[1]},
+			},
+			want: []string{"ok", "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		release := make(chan struct{})
+		var once sync.Once
+		cli := CLI{Parallelism: tt.parallelism, Logger: logrus.New()}
+		cli.StsClient = mockStsClient(
+			func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				full := running == tt.parallelism
+				mu.Unlock()
+				if full {
+					once.Do(func() { close(release) })
+				}
+				<-release
+				mu.Lock()
+				running--
+				mu.Unlock()
+				if *params.RoleArn == multiRoles[2].Name {
+					return nil, errors.New("access denied")
+				}
+				return &sts.AssumeRoleWithSAMLOutput{
+					Credentials: &stsTypes.Credentials{
+						AccessKeyId:     &accessKeyId,
+						SecretAccessKey: &secretAccessKey,
+						SessionToken:    &sessionToken,
+					},
+				}, nil
+			})
+		results := cli.assumeRoles(context.Background(), tt.targets, "")
+		var got []string
+		for _, result := range results {
+			if result.Err != nil {
+				got = append(got, "failed: "+result.Err.Error())
+			} else {
+				got = append(got, "ok")
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+		if maxRunning > tt.parallelism {
+			t.Errorf("Error running test -- got: %d concurrent calls want: at most %d", maxRunning, tt.parallelism)
+		}
+
+		var out bytes.Buffer
+		printResults(&out, results)
+		if !strings.HasPrefix(out.String(), "PROFILE") || strings.Count(out.String(), "\n") != len(results)+1 {
+			t.Errorf("Error running test -- got: %v", out.String())
+		}
+	}
+}
+
+func Test_Profile_Targets(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []config.Profile
+		want     []string
+	}{
+		{
+			name: "Validate profile regions and durations override the defaults",
+			profiles: []config.Profile{
+				{Name: "dev", Settings: config.Settings{RoleArn: "arn:aws:iam::111111111111:role/AdministratorAccess"}},
+				{Name: "prod", Settings: config.Settings{RoleArn: "arn:aws:iam::333333333333:role/ReadOnly", Region: "eu-west-1", Duration: 3600}},
+			},
+			want: []string{
+				"dev arn:aws:iam::111111111111:role/AdministratorAccess us-east-1 900 <nil>",
+				"prod arn:aws:iam::333333333333:role/ReadOnly eu-west-1 3600 <nil>",
+			},
+		},
+		{
+			name: "Validate a role missing from the assertion fails only its profile",
+			profiles: []config.Profile{
+				{Name: "sandbox", Settings: config.Settings{RoleArn: "arn:aws:iam::444444444444:role/AdministratorAccess"}},
+				{Name: "staging", Settings: config.Settings{RoleArn: "arn:aws:iam::222222222222:role/AdministratorAccess"}},
+			},
+			want: []string{
+				"sandbox arn:aws:iam::444444444444:role/AdministratorAccess us-east-1 900 no role matches --role arn:aws:iam::444444444444:role/AdministratorAccess",
+				"staging arn:aws:iam::222222222222:role/AdministratorAccess us-east-1 900 <nil>",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		var got []string
+		for _, target := range profileTargets(multiRoles, tt.profiles, "us-east-1", 900) {
+			got = append(got, fmt.Sprintf("%s %s %s %d %v", target.Profile, target.Role.Name, target.Region, target.Duration, target.Err))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Test_Login_All(t *testing.T) {
+	accessKeyId := "someaccesskeyid"
+	secretAccessKey := "somesecretaccesskey"
+	sessionToken := "somesessiontoken"
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fixture := testLoginSuccess
+		if req.URL.Path == "/" {
+			fixture = testLoginPage
+		}
+		testBody, _ := ioutil.ReadFile(fixture)
+		rw.Write(testBody)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	requested := map[string]int32{}
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	cli := CLI{
+		IdpEntryUrl:     server.URL,
+		Region:          "us-east-1",
+		Duration:        900,
+		CredentialsFile: credentialsFile,
+		Logger:          logrus.New(),
+		StsClient: mockStsClient(
+			func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+				mu.Lock()
+				requested[*params.RoleArn] = *params.DurationSeconds
+				mu.Unlock()
+				return &sts.AssumeRoleWithSAMLOutput{
+					Credentials: &stsTypes.Credentials{
+						AccessKeyId:     &accessKeyId,
+						SecretAccessKey: &secretAccessKey,
+						SessionToken:    &sessionToken,
+					},
+				}, nil
+			}),
+	}
+	cli.User = types.User{Username: "potato", Password: "cheese", Domain: "domain"}
+	err := cli.LoginAll(context.Background(), []config.Profile{
+		{Name: "prod", Settings: config.Settings{RoleArn: "arn:aws:iam::123456789123:role/AdministratorAccess", Region: "eu-west-1", Duration: 3600}},
+		{Name: "dev", Settings: config.Settings{RoleArn: "arn:aws:iam::987654321321:role/DeveloperAccess"}},
+	})
+	if err != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, nil)
+	}
+
+	wantRequested := map[string]int32{
+		"arn:aws:iam::123456789123:role/AdministratorAccess": 3600,
+		"arn:aws:iam::987654321321:role/DeveloperAccess":     900,
+	}
+	if !reflect.DeepEqual(requested, wantRequested) {
+		t.Errorf("Error running test -- got: %v want: %v", requested, wantRequested)
+	}
+	content, _ := os.ReadFile(credentialsFile)
+	for _, want := range []string{"[dev]\nregion=us-east-1", "[prod]\nregion=eu-west-1"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Error running test -- got: %v want: %v", string(content), want)
+		}
+	}
+}
+
+func Test_Login_Role_Map_Keeps_Profiles(t *testing.T) {
+	accessKeyId := "someaccesskeyid"
+	secretAccessKey := "somesecretaccesskey"
+	sessionToken := "somesessiontoken"
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fixture := testLoginSuccess
+		if req.URL.Path == "/" {
+			fixture = testLoginPage
+		}
+		testBody, _ := ioutil.ReadFile(fixture)
+		rw.Write(testBody)
+	}))
+	defer server.Close()
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	os.WriteFile(credentialsFile, []byte("[personal]\naws_access_key_id=mine\n\n[prod]\naws_access_key_id=old\n"), 0600)
+	cli := CLI{
+		IdpEntryUrl:     server.URL,
+		Region:          "us-east-1",
+		Duration:        900,
+		CredentialsFile: credentialsFile,
+		Logger:          logrus.New(),
+		RoleMap: map[string]string{
+			"AdministratorAccess": "prod",
+			"DeveloperAccess":     "dev",
+		},
+		StsClient: mockStsClient(
+			func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+				return &sts.AssumeRoleWithSAMLOutput{
+					Credentials: &stsTypes.Credentials{
+						AccessKeyId:     &accessKeyId,
+						SecretAccessKey: &secretAccessKey,
+						SessionToken:    &sessionToken,
+					},
+				}, nil
+			}),
+	}
+	cli.User = types.User{Username: "potato", Password: "cheese", Domain: "domain"}
+	if err := cli.Login(context.Background()); err != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, nil)
+	}
+
+	content, _ := os.ReadFile(credentialsFile)
+	for _, want := range []string{"[personal]\naws_access_key_id=mine", "[prod]\nregion=us-east-1", "[dev]\nregion=us-east-1"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Error running test -- got: %v want: %v", string(content), want)
+		}
+	}
+	if strings.Contains(string(content), "aws_access_key_id=old") {
+		t.Errorf("Error running test -- got: %v want: the old prod profile replaced", string(content))
+	}
+}
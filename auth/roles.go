@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/S7R4nG3/aws-adfs-login/prompts"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+)
+
+// Chooses the AWS role to assume. The --role and --account filters are applied to the
+// roles from the SAML assertion; a single remaining role is selected automatically,
+// several remaining roles are offered in the interactive prompt unless a filter was
+// given, in which case the ambiguity is an error listing the candidates. The last
+// selected role is pre-selected in the prompt, or chosen directly with --last.
+func (cli CLI) selectRole(roles []types.Role, last string) (types.Role, error) {
+	if len(roles) == 0 {
+		return types.Role{}, types.NewError(types.ErrNoRoles, "no AWS roles found in the SAML assertion", nil)
+	}
+	candidates := filterRoles(roles, cli.RoleFilter, cli.AccountFilter)
+	filtered := cli.RoleFilter != "" || cli.AccountFilter != ""
+	switch {
+	case len(candidates) == 1:
+		cli.Logger.Infof("Selected role %s", candidates[0].Name)
+		return candidates[0], nil
+	case len(candidates) == 0:
+		return types.Role{}, types.NewError(types.ErrNoRoles, fmt.Sprintf("no role matches %s, available roles:\n%s", describeFilters(cli.RoleFilter, cli.AccountFilter), listRoles(roles)), nil)
+	case filtered:
+		return types.Role{}, fmt.Errorf("%s matches several roles, candidates:\n%s", describeFilters(cli.RoleFilter, cli.AccountFilter), listRoles(candidates))
+	}
+	if cli.UseLast && last != "" {
+		for _, role := range candidates {
+			if role.Name == last {
+				cli.Logger.Infof("Selected last used role %s", role.Name)
+				return role, nil
+			}
+		}
+		cli.Logger.Warnf("Last used role %s is no longer available", last)
+	}
+	return prompts.RoleSelect(candidates, last)
+}
+
+// Filters roles by a full ARN, role name or glob pattern, and by an account ID or alias.
+func filterRoles(roles []types.Role, role string, account string) []types.Role {
+	var matches []types.Role
+	for _, r := range roles {
+		if role != "" && !matchesRole(r, role) {
+			continue
+		}
+		if account != "" && r.AccountId() != account && !strings.EqualFold(r.AccountAlias, account) {
+			continue
+		}
+		matches = append(matches, r)
+	}
+	return matches
+}
+
+func matchesRole(r types.Role, pattern string) bool {
+	if r.Name == pattern || strings.EqualFold(r.RoleName(), pattern) {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return false
+	}
+	if matched, _ := path.Match(pattern, r.Name); matched {
+		return true
+	}
+	matched, _ := path.Match(pattern, r.RoleName())
+	return matched
+}
+
+func describeFilters(role string, account string) string {
+	var filters []string
+	if role != "" {
+		filters = append(filters, "--role "+role)
+	}
+	if account != "" {
+		filters = append(filters, "--account "+account)
+	}
+	return strings.Join(filters, " ")
+}
+
+func listRoles(roles []types.Role) string {
+	lines := make([]string, len(roles))
+	for i, role := range roles {
+		lines[i] = "  " + role.Label() + "  " + role.Name
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/sirupsen/logrus"
+)
+
+func Test_Select_Role(t *testing.T) {
+	roles := []types.Role{
+		{
+			Name:         "arn:aws:iam::111111111111:role/AdministratorAccess",
+			PrincipalArn: "arn:aws:iam::111111111111:saml-provider/ADFS",
+			AccountAlias: "dev",
+		},
+		{
+			Name:         "arn:aws:iam::111111111111:role/ReadOnly",
+			PrincipalArn: "arn:aws:iam::111111111111:saml-provider/ADFS",
+			AccountAlias: "dev",
+		},
+		{
+			Name:         "arn:aws:iam::123456789123:role/AdministratorAccess",
+			PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+			AccountAlias: "prod",
+		},
+		{
+			Name:         "arn:aws:iam::123456789123:role/team/DeveloperAccess",
+			PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+			AccountAlias: "prod",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		roles   []types.Role
+		input   CLI
+		last    string
+		want    types.Role
+		wantErr string
+	}{
+		{
+			name:  "Validate a full ARN selects the role",
+			roles: roles,
+			input: CLI{RoleFilter: "arn:aws:iam::123456789123:role/AdministratorAccess"},
+			want:  roles[2],
+		},
+		{
+			name:  "Validate a role name and account alias select the role",
+			roles: roles,
+			input: CLI{RoleFilter: "administratoraccess", AccountFilter: "PROD"},
+			want:  roles[2],
+		},
+		{
+			name:  "Validate a role name and account ID select the role",
+			roles: roles,
+			input: CLI{RoleFilter: "AdministratorAccess", AccountFilter: "111111111111"},
+			want:  roles[0],
+		},
+		{
+			name:  "Validate a unique role name selects the role",
+			roles: roles,
+			input: CLI{RoleFilter: "ReadOnly"},
+			want:  roles[1],
+		},
+		{
+			name:  "Validate globs match role names",
+			roles: roles,
+			input: CLI{RoleFilter: "Dev*"},
+			want:  roles[3],
+		},
+		{
+			name:  "Validate globs match full ARNs",
+			roles: roles,
+			input: CLI{RoleFilter: "arn:aws:iam::1111*:role/Read*"},
+			want:  roles[1],
+		},
+		{
+			name:  "Validate a single available role is selected automatically",
+			roles: roles[:1],
+			input: CLI{},
+			want:  roles[0],
+		},
+		{
+			name:  "Validate --last selects the last used role",
+			roles: roles,
+			input: CLI{UseLast: true},
+			last:  "arn:aws:iam::123456789123:role/team/DeveloperAccess",
+			want:  roles[3],
+		},
+		{
+			name:  "Validate filters take precedence over the last used role",
+			roles: roles,
+			input: CLI{UseLast: true, RoleFilter: "ReadOnly"},
+			last:  "arn:aws:iam::123456789123:role/team/DeveloperAccess",
+			want:  roles[1],
+		},
+		{
+			name:    "Validate ambiguous matches list the candidates",
+			roles:   roles,
+			input:   CLI{RoleFilter: "AdministratorAccess"},
+			wantErr: "matches several roles, candidates:\n  dev (111111111111) - AdministratorAccess",
+		},
+		{
+			name:    "Validate missing matches list the available roles",
+			roles:   roles,
+			input:   CLI{AccountFilter: "staging"},
+			wantErr: "no role matches --account staging, available roles:",
+		},
+		{
+			name:    "Validate an assertion without roles is an error",
+			input:   CLI{RoleFilter: "AdministratorAccess"},
+			wantErr: "no AWS roles found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		tt.input.Logger = logrus.New()
+		got, err := tt.input.selectRole(tt.roles, tt.last)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Error running test -- got: %v want error containing: %s", err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Error running test -- got: %v (%v) want: %v", got, err, tt.want)
+		}
+	}
+}
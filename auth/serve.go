@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/logging"
+	"github.com/S7R4nG3/aws-adfs-login/saml"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	stsTypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+const (
+	// The path the credentials of each profile are served under, followed by the profile name
+	ProfilesPath = "/profiles/"
+	// How long before the SAML assertion expires the server stops assuming roles with it
+	assertionMargin = time.Minute
+)
+
+// The credentials of a profile in the format of the ECS container credentials
+// endpoint, read by the AWS SDKs from AWS_CONTAINER_CREDENTIALS_FULL_URI.
+type ContainerCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+	RoleArn         string
+}
+
+// The body of a failed request, in the format of the ECS credentials endpoint.
+type containerError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Serves the credentials of the configured profiles to the AWS SDKs as an ECS
+// container credentials endpoint. Each profile assumes its role on its first
+// request, and again once its credentials are within RefreshWindow of expiring,
+// or half way through their lifetime when RefreshWindow isn't shorter. The
+// profiles share the SAML assertion of one login until it expires, and refresh
+// independently of each other. Requests must carry the Token in their
+// Authorization header. Once the IdP rejects the login the server stops signing
+// in until it is restarted, as signing in again for every request would lock
+// the account.
+type CredentialServer struct {
+	CLI           CLI
+	Profiles      []config.Profile
+	Token         string
+	RefreshWindow time.Duration
+	// The clock, replaced in tests
+	now func() time.Time
+	// Guards cache and locks
+	mu    sync.Mutex
+	cache map[string]servedCredentials
+	// Serializes the refreshes of each profile
+	locks map[string]*sync.Mutex
+	// Serializes the logins and guards the fields below
+	loginMu sync.Mutex
+	// The last login, shared by the profiles until assertionExpires
+	assertion        *saml.Saml
+	assertionExpires time.Time
+	// The rejected login, returned to every later refresh
+	authErr error
+}
+
+// The credentials of a profile and when to refresh them.
+type servedCredentials struct {
+	creds     *stsTypes.Credentials
+	refreshAt time.Time
+}
+
+// Creates a credentials server for the profiles with the DefaultRefreshWindow.
+func NewCredentialServer(cli CLI, profiles []config.Profile, token string) *CredentialServer {
+	logging.AddSecret(cli.Logger, token)
+	return &CredentialServer{
+		CLI:           cli,
+		Profiles:      profiles,
+		Token:         token,
+		RefreshWindow: DefaultRefreshWindow,
+		now:           time.Now,
+		cache:         map[string]servedCredentials{},
+		locks:         map[string]*sync.Mutex{},
+	}
+}
+
+// Resolves the login password once, then serves requests on the listener until
+// the context is cancelled.
+func (s *CredentialServer) Serve(ctx context.Context, listener net.Listener) error {
+	if s.Token == "" {
+		return errors.New("starting the credentials server: an authorization token is required")
+	}
+	user, err := s.CLI.setupCredentials()
+	if err != nil {
+		return err
+	}
+	s.CLI.User = user
+	if s.CLI.StsClient == nil {
+		if s.CLI.StsClient, err = NewStsClient(ctx, s.CLI.Region); err != nil {
+			return err
+		}
+	}
+	server := &http.Server{
+		Handler:     s,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving credentials: %w", err)
+	}
+	return nil
+}
+
+func (s *CredentialServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	log := s.CLI.Logger
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(s.Token)) != 1 {
+		writeContainerError(rw, http.StatusUnauthorized, "AccessDenied", "missing or invalid authorization token")
+		return
+	}
+	if req.Method != http.MethodGet {
+		writeContainerError(rw, http.StatusMethodNotAllowed, "InvalidRequest", "only GET is supported")
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, ProfilesPath)
+	profile, ok := s.profile(name)
+	if !strings.HasPrefix(req.URL.Path, ProfilesPath) || !ok {
+		writeContainerError(rw, http.StatusNotFound, "NotFound", "no profile "+name+" in the configuration")
+		return
+	}
+	creds, err := s.credentials(req.Context(), profile)
+	if err != nil {
+		log.Errorf("Unable to retrieve the credentials of profile %s -- %v", profile.Name, err)
+		writeContainerError(rw, http.StatusBadGateway, "CredentialsUnavailable", err.Error())
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(ContainerCredentials{
+		AccessKeyId:     getPointerValue(creds.AccessKeyId),
+		SecretAccessKey: getPointerValue(creds.SecretAccessKey),
+		Token:           getPointerValue(creds.SessionToken),
+		Expiration:      creds.Expiration.UTC(),
+		RoleArn:         profile.RoleArn,
+	})
+}
+
+// Returns the cached credentials of the profile, assuming its role when there
+// are none or they are due for a refresh.
+func (s *CredentialServer) credentials(ctx context.Context, profile config.Profile) (*stsTypes.Credentials, error) {
+	log := s.CLI.Logger
+	lock := s.profileLock(profile.Name)
+	lock.Lock()
+	defer lock.Unlock()
+	s.mu.Lock()
+	cached := s.cache[profile.Name]
+	s.mu.Unlock()
+	if cached.creds != nil && s.now().Before(cached.refreshAt) {
+		return cached.creds, nil
+	}
+	log.Infof("Refreshing the credentials of profile %s", profile.Name)
+	saml, err := s.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target := profileTargets(saml.Roles, []config.Profile{profile}, s.CLI.Region, s.CLI.sessionDuration(*saml))[0]
+	if target.Err != nil {
+		return nil, target.Err
+	}
+	output, _, err := s.CLI.RoleCredentials(ctx, target.Role, target.Duration, saml.Assertion)
+	if err != nil {
+		return nil, err
+	}
+	if output.Credentials == nil || output.Credentials.Expiration == nil {
+		return nil, errors.New("STS returned no credentials expiration")
+	}
+	removeCredentialSecrets(log, cached.creds, output.Credentials)
+	s.mu.Lock()
+	s.cache[profile.Name] = servedCredentials{creds: output.Credentials, refreshAt: s.refreshTime(profile.Name, *output.Credentials.Expiration)}
+	s.mu.Unlock()
+	return output.Credentials, nil
+}
+
+// Returns the last login while its assertion is valid, signing in again otherwise.
+func (s *CredentialServer) login(ctx context.Context) (*saml.Saml, error) {
+	log := s.CLI.Logger
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	if s.authErr != nil {
+		return nil, s.authErr
+	}
+	if s.assertion != nil && s.now().Before(s.assertionExpires) {
+		return s.assertion, nil
+	}
+	login, err := s.CLI.authenticate(ctx)
+	if errors.Is(err, types.ErrAuthFailed) {
+		log.Errorf("The IdP rejected the login, restart the server to sign in again -- %v", err)
+		s.authErr = err
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.assertion = &login
+	// An assertion without a valid NotOnOrAfter is used once
+	s.assertionExpires = time.Time{}
+	if notOnOrAfter := login.SamlXMLResponse.Conditions.NotOnOrAfter; notOnOrAfter != "" {
+		if expires, err := time.Parse(time.RFC3339, notOnOrAfter); err != nil {
+			log.Warnf("Ignoring invalid SAML NotOnOrAfter %q", notOnOrAfter)
+		} else {
+			s.assertionExpires = expires.Add(-assertionMargin)
+		}
+	}
+	return s.assertion, nil
+}
+
+// When to refresh credentials expiring at expiration, RefreshWindow before or
+// half way through their lifetime when RefreshWindow isn't shorter than it, so a
+// long window doesn't refresh them on every request.
+func (s *CredentialServer) refreshTime(name string, expiration time.Time) time.Time {
+	now := s.now()
+	halfway := now.Add(expiration.Sub(now) / 2)
+	refreshAt := expiration.Add(-s.RefreshWindow)
+	if refreshAt.Before(halfway) {
+		s.CLI.Logger.Warnf("The refresh window %s isn't shorter than the credentials of profile %s, refreshing them at %s", s.RefreshWindow, name, halfway.Format(time.RFC3339))
+		return halfway
+	}
+	return refreshAt
+}
+
+// The lock serializing the refreshes of the profile.
+func (s *CredentialServer) profileLock(name string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[name] = lock
+	}
+	return lock
+}
+
+func (s *CredentialServer) profile(name string) (config.Profile, bool) {
+	for _, profile := range s.Profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return config.Profile{}, false
+}
+
+func writeContainerError(rw http.ResponseWriter, status int, code string, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(containerError{Code: code, Message: message})
+}
+
+// Listens on the address, which must be a loopback address as the credentials
+// are served over plain HTTP.
+func ListenLoopback(address string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("listening on %s: the credentials server only listens on loopback addresses such as 127.0.0.1", address)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", address, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,412 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/logging"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const testToken = "sometoken"
+
+func Test_Credential_Server(t *testing.T) {
+	var logins int32
+	portal := newDaemonPortal(t, &logins)
+	granting := NewCredentialServer(newDaemonCli(t, portal.URL, expiringSts(time.Now, time.Hour, "")), daemonProfiles, testToken)
+	denying := NewCredentialServer(newDaemonCli(t, portal.URL, expiringSts(time.Now, time.Hour, daemonProfiles[0].RoleArn)), daemonProfiles, testToken)
+
+	tests := []struct {
+		name       string
+		server     *CredentialServer
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantCode   string
+	}{
+		{
+			name:       "Profile credentials are served...",
+			server:     granting,
+			method:     http.MethodGet,
+			path:       "/profiles/prod",
+			token:      testToken,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Missing token is refused...",
+			server:     granting,
+			method:     http.MethodGet,
+			path:       "/profiles/prod",
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "AccessDenied",
+		},
+		{
+			name:       "Wrong token is refused...",
+			server:     granting,
+			method:     http.MethodGet,
+			path:       "/profiles/prod",
+			token:      "othertoken",
+			wantStatus: http.StatusUnauthorized,
+			wantCode:   "AccessDenied",
+		},
+		{
+			name:       "Other methods are refused...",
+			server:     granting,
+			method:     http.MethodPost,
+			path:       "/profiles/prod",
+			token:      testToken,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCode:   "InvalidRequest",
+		},
+		{
+			name:       "Unknown profile is not found...",
+			server:     granting,
+			method:     http.MethodGet,
+			path:       "/profiles/staging",
+			token:      testToken,
+			wantStatus: http.StatusNotFound,
+			wantCode:   "NotFound",
+		},
+		{
+			name:       "Paths outside the profiles are not found...",
+			server:     granting,
+			method:     http.MethodGet,
+			path:       "/prod",
+			token:      testToken,
+			wantStatus: http.StatusNotFound,
+			wantCode:   "NotFound",
+		},
+		{
+			name:       "STS rejection is reported...",
+			server:     denying,
+			method:     http.MethodGet,
+			path:       "/profiles/prod",
+			token:      testToken,
+			wantStatus: http.StatusBadGateway,
+			wantCode:   "CredentialsUnavailable",
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", tt.token)
+		}
+		rw := httptest.NewRecorder()
+		tt.server.ServeHTTP(rw, req)
+		if rw.Code != tt.wantStatus {
+			t.Errorf("Error running test -- got: %v want: %v", rw.Code, tt.wantStatus)
+		}
+		if tt.wantCode == "" {
+			var creds ContainerCredentials
+			json.NewDecoder(rw.Body).Decode(&creds)
+			if creds.AccessKeyId != "someaccesskeyid" || creds.Token != "somesessiontoken" || creds.RoleArn != daemonProfiles[0].RoleArn {
+				t.Errorf("Error running test -- got: %+v", creds)
+			}
+			continue
+		}
+		var body containerError
+		json.NewDecoder(rw.Body).Decode(&body)
+		if body.Code != tt.wantCode {
+			t.Errorf("Error running test -- got: %v want: %v", body.Code, tt.wantCode)
+		}
+	}
+}
+
+func Test_Credential_Server_Cache(t *testing.T) {
+	var logins int32
+	portal := newDaemonPortal(t, &logins)
+	now := time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	server := NewCredentialServer(newDaemonCli(t, portal.URL, expiringSts(clock, time.Hour, "")), daemonProfiles, testToken)
+	server.now = clock
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		profile    string
+		wantLogins int32
+	}{
+		{
+			name:       "First request signs in...",
+			profile:    "prod",
+			wantLogins: 1,
+		},
+		{
+			name:       "Valid credentials are reused...",
+			advance:    30 * time.Minute,
+			profile:    "prod",
+			wantLogins: 1,
+		},
+		{
+			name:       "Each profile is cached separately...",
+			profile:    "dev",
+			wantLogins: 2,
+		},
+		{
+			name:       "Credentials within the refresh window are refreshed...",
+			advance:    26 * time.Minute,
+			profile:    "prod",
+			wantLogins: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		now = now.Add(tt.advance)
+		req := httptest.NewRequest(http.MethodGet, ProfilesPath+tt.profile, nil)
+		req.Header.Set("Authorization", testToken)
+		rw := httptest.NewRecorder()
+		server.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Errorf("Error running test -- got: %v want: %v", rw.Code, http.StatusOK)
+		}
+		if got := atomic.LoadInt32(&logins); got != tt.wantLogins {
+			t.Errorf("Error running test -- got: %v logins want: %v", got, tt.wantLogins)
+		}
+	}
+}
+
+func Test_Credential_Server_Sdk(t *testing.T) {
+	var logins int32
+	portal := newDaemonPortal(t, &logins)
+	listener, err := ListenLoopback("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening -- %v", err)
+	}
+	server := NewCredentialServer(newDaemonCli(t, portal.URL, expiringSts(time.Now, time.Hour, "")), daemonProfiles, testToken)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- server.Serve(ctx, listener) }()
+
+	// The provider the AWS SDK uses for AWS_CONTAINER_CREDENTIALS_FULL_URI
+	provider := endpointcreds.New("http://"+listener.Addr().String()+ProfilesPath+"dev", func(o *endpointcreds.Options) {
+		o.AuthorizationToken = testToken
+	})
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, nil)
+	}
+	if creds.AccessKeyID != "someaccesskeyid" || !creds.CanExpire {
+		t.Errorf("Error running test -- got: %+v", creds)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Error running test -- got: %v want: %v", err, nil)
+	}
+}
+
+func Test_Listen_Loopback(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		wantErr string
+	}{
+		{
+			name:    "Loopback address is accepted...",
+			address: "127.0.0.1:0",
+		},
+		{
+			name:    "Localhost is accepted...",
+			address: "localhost:0",
+		},
+		{
+			name:    "Other addresses are refused...",
+			address: "0.0.0.0:0",
+			wantErr: "only listens on loopback addresses",
+		},
+		{
+			name:    "Address without a port is refused...",
+			address: "127.0.0.1",
+			wantErr: "missing port",
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		listener, err := ListenLoopback(tt.address)
+		if err == nil {
+			listener.Close()
+		}
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Error running test -- got: %v want: %v", err, tt.wantErr)
+		}
+	}
+}
+
+func Test_Credential_Server_Forgets_Replaced_Credentials(t *testing.T) {
+	var logins, calls int32
+	portal := newDaemonPortal(t, &logins)
+	now := time.Now()
+	clock := func() time.Time { return now }
+	var output bytes.Buffer
+	cli := newDaemonCli(t, portal.URL, numberedSts(&calls))
+	cli.Logger = logging.New()
+	cli.Logger.SetOutput(&output)
+	server := NewCredentialServer(cli, daemonProfiles, testToken)
+	server.now = clock
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, ProfilesPath+"prod", nil)
+		req.Header.Set("Authorization", testToken)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+		now = now.Add(time.Hour)
+	}
+	output.Reset()
+	cli.Logger.Info("sessiontoken-1 sessiontoken-2")
+	if got := output.String(); !strings.Contains(got, "sessiontoken-1 [REDACTED]") {
+		t.Errorf("Error running test -- got: %v want: %v", got, "sessiontoken-1 [REDACTED]")
+	}
+}
+
+func Test_Credential_Server_Auth_Failed(t *testing.T) {
+	var attempts int32
+	rejecting := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			atomic.AddInt32(&attempts, 1)
+		}
+		testBody, _ := ioutil.ReadFile(testLoginPage)
+		rw.Write(testBody)
+	}))
+	defer rejecting.Close()
+	server := NewCredentialServer(newDaemonCli(t, rejecting.URL, expiringSts(time.Now, time.Hour, "")), daemonProfiles, testToken)
+
+	for _, profile := range []string{"prod", "prod", "dev"} {
+		req := httptest.NewRequest(http.MethodGet, ProfilesPath+profile, nil)
+		req.Header.Set("Authorization", testToken)
+		rw := httptest.NewRecorder()
+		server.ServeHTTP(rw, req)
+		if rw.Code != http.StatusBadGateway {
+			t.Errorf("Error running test -- got: %v want: %v", rw.Code, http.StatusBadGateway)
+		}
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("Error running test -- got: %v logins want: %v", got, 1)
+	}
+}
+
+func Test_Credential_Server_Shares_Assertion(t *testing.T) {
+	var logins int32
+	portal := newDaemonPortal(t, &logins)
+	// The fixture assertion is valid until 06:40:41
+	now := time.Date(2016, 10, 8, 5, 41, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	var calls int32
+	granting := expiringSts(clock, 15*time.Minute, "")
+	counting := mockStsClient(func(ctx context.Context, params *sts.AssumeRoleWithSAMLInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithSAMLOutput, error) {
+		atomic.AddInt32(&calls, 1)
+		return granting(ctx, params, optFns...)
+	})
+	server := NewCredentialServer(newDaemonCli(t, portal.URL, counting), daemonProfiles, testToken)
+	server.now = clock
+	server.RefreshWindow = time.Hour
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		profile    string
+		wantLogins int32
+	}{
+		{
+			name:       "First request signs in...",
+			profile:    "prod",
+			wantLogins: 1,
+		},
+		{
+			name:       "Other profiles reuse the assertion...",
+			profile:    "dev",
+			wantLogins: 1,
+		},
+		{
+			name:       "A refresh window longer than the credentials doesn't refresh every request...",
+			advance:    5 * time.Minute,
+			profile:    "prod",
+			wantLogins: 1,
+		},
+		{
+			name:       "Refreshes reuse the assertion while it is valid...",
+			advance:    5 * time.Minute,
+			profile:    "prod",
+			wantLogins: 1,
+		},
+		{
+			name:       "Refreshes sign in again once the assertion expires...",
+			advance:    time.Hour,
+			profile:    "dev",
+			wantLogins: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		now = now.Add(tt.advance)
+		req := httptest.NewRequest(http.MethodGet, ProfilesPath+tt.profile, nil)
+		req.Header.Set("Authorization", testToken)
+		rw := httptest.NewRecorder()
+		server.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Errorf("Error running test -- got: %v want: %v", rw.Code, http.StatusOK)
+		}
+		if got := atomic.LoadInt32(&logins); got != tt.wantLogins {
+			t.Errorf("Error running test -- got: %v logins want: %v", got, tt.wantLogins)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 4 {
+		t.Errorf("Error running test -- got: %v STS calls want: %v", got, 4)
+	}
+}
+
+func Test_Credential_Server_Serves_During_Login(t *testing.T) {
+	var logins int32
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	portal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fixture := testLoginSuccess
+		if req.URL.Path == "/" {
+			fixture = testLoginPage
+		} else if atomic.AddInt32(&logins, 1) > 1 {
+			entered <- struct{}{}
+			<-release
+		}
+		testBody, _ := ioutil.ReadFile(fixture)
+		rw.Write(testBody)
+	}))
+	defer portal.Close()
+	server := NewCredentialServer(newDaemonCli(t, portal.URL, expiringSts(time.Now, time.Hour, "")), daemonProfiles, testToken)
+	request := func(profile string) chan int {
+		done := make(chan int, 1)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, ProfilesPath+profile, nil)
+			req.Header.Set("Authorization", testToken)
+			rw := httptest.NewRecorder()
+			server.ServeHTTP(rw, req)
+			done <- rw.Code
+		}()
+		return done
+	}
+
+	// The expired fixture assertion makes prod sign in again
+	<-request("dev")
+	prod := request("prod")
+	<-entered
+	select {
+	case got := <-request("dev"):
+		if got != http.StatusOK {
+			t.Errorf("Error running test -- got: %v want: %v", got, http.StatusOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Error running test -- got: %v want: %v", "dev waiting on the prod login", "cached dev credentials")
+	}
+	close(release)
+	if got := <-prod; got != http.StatusOK {
+		t.Errorf("Error running test -- got: %v want: %v", got, http.StatusOK)
+	}
+}
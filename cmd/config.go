@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/keyring"
+	"github.com/S7R4nG3/aws-adfs-login/prompts"
+	"github.com/S7R4nG3/aws-adfs-login/saml"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// The flag for each setting, see config.SettingKeys.
+	settingFlags = map[string]string{
+		"idp_url":         "idpEntryUrl",
+		"region":          "region",
+		"duration":        "duration",
+		"username":        "username",
+		"domain":          "domain",
+		"username_format": "username-format",
+		"ca_bundle":       "ca-bundle",
+		"role_arn":        "role",
+		"keyring":         "keyring",
+	}
+	// The environment variables for each setting, the first one set is used.
+	settingEnv = map[string][]string{
+		"region":   {"AWS_REGION", "AWS_DEFAULT_REGION"},
+		"username": {"AWS_USERNAME"},
+		"domain":   {"ADFS_DOMAIN"},
+	}
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the aws-adfs-login configuration.",
+	}
+	configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Prints the effective settings for a profile and where each comes from.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			settings, sources, err := effectiveSettings(cmd, cfg, cli.Profile)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Configuration: %s\n", configPath)
+			fmt.Printf("AWS config:    %s\n", config.DefaultAwsConfigPath())
+			fmt.Printf("Profile:       %s\n\n", cli.Profile)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+			for _, key := range config.SettingKeys() {
+				value, source := settings.Get(key), sources[key]
+				if value == "" {
+					value, source = "-", "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", key, value, source)
+			}
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	configShowCmd.Flags().StringVarP(&cli.Profile, "profile", "", "default", "The name of your AWS credentials profile.")
+	configShowCmd.Flags().StringVarP(&cli.IdpEntryUrl, "idpEntryUrl", "i", "", "The IDP Entry URL for your ADFS environment.")
+	configShowCmd.Flags().StringVarP(&cli.Region, "region", "r", "", "The AWS region.")
+	configShowCmd.Flags().StringVarP(&configPath, "config", "", configPath, "Path to the aws-adfs-login configuration file.")
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// Merges the configuration of the profile with the environment and the flags set on
+// the command, flags taking precedence over the environment and the environment over
+// the configuration. Returns the settings and the source of each value.
+func effectiveSettings(cmd *cobra.Command, cfg config.Config, profile string) (config.Settings, map[string]string, error) {
+	var env []config.Layer
+	flags := config.Layer{Source: "flag"}
+	for _, key := range config.SettingKeys() {
+		for _, name := range settingEnv[key] {
+			if value := os.Getenv(name); value != "" {
+				layer := config.Layer{Source: "env " + name}
+				layer.Settings.Set(key, value)
+				env = append(env, layer)
+				break
+			}
+		}
+		flag := cmd.Flags().Lookup(settingFlags[key])
+		if flag == nil || !flag.Changed || (key == "duration" && flag.Value.String() == "max") {
+			continue
+		}
+		if err := flags.Settings.Set(key, flag.Value.String()); err != nil {
+			return config.Settings{}, nil, fmt.Errorf("invalid --%s: %w", flag.Name, err)
+		}
+	}
+	layers := append(cfg.Layers(profile, flags.Settings.IdpUrl), env...)
+	layers = append(layers, flags)
+	settings, sources := config.Resolve(layers)
+	return settings, sources, nil
+}
+
+// Applies the effective settings to the CLI, the IdP entry URL is required.
+func applySettings(cmd *cobra.Command, cfg config.Config, profile string) error {
+	settings, _, err := effectiveSettings(cmd, cfg, profile)
+	if err != nil {
+		return err
+	}
+	if settings.IdpUrl == "" {
+		return errors.New("missing IDP Entry URL: set --idpEntryUrl or idp_url in the configuration")
+	}
+	cli.IdpEntryUrl = settings.IdpUrl
+	cli.Region = settings.Region
+	cli.CABundle = settings.CABundle
+	cli.UsernameFormat = settings.UsernameFormat
+	if !validUsernameFormat(settings.UsernameFormat) {
+		return fmt.Errorf("invalid username format: unknown username format %q, expected one of %s", settings.UsernameFormat, strings.Join(saml.UsernameFormats, ", "))
+	}
+	cli.RoleFilter = settings.RoleArn
+	if cli.Keyring, err = openKeyring(settings.Keyring); err != nil {
+		return err
+	}
+	cli.User.Username = settings.Username
+	cli.User.Domain = settings.Domain
+	if !cli.DurationMax {
+		cli.Duration = defaultDuration
+		if settings.Duration > 0 {
+			cli.Duration = settings.Duration
+		}
+	}
+	return nil
+}
+
+func validUsernameFormat(format string) bool {
+	if format == "" {
+		return true
+	}
+	for _, f := range saml.UsernameFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Opens the keyring backend, nil when none is available.
+func openKeyring(backend string) (keyring.Keyring, error) {
+	k, err := keyring.Open(backend, keyring.Options{Passphrase: keyringPassphrase})
+	if errors.Is(err, keyring.ErrUnavailable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening keyring: %w", err)
+	}
+	return k, nil
+}
+
+// The passphrase of the file keyring from AWS_LOGIN_KEYRING_PASSPHRASE, or prompted.
+func keyringPassphrase() (string, error) {
+	if passphrase, ok := os.LookupEnv("AWS_LOGIN_KEYRING_PASSPHRASE"); ok {
+		return passphrase, nil
+	}
+	return prompts.Passphrase()
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/prompts"
+	"github.com/S7R4nG3/aws-adfs-login/saml"
+	"github.com/spf13/cobra"
+)
+
+var (
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+	// The username format options offered, in the order of saml.UsernameFormats
+	usernameFormatOptions = []string{
+		`DOMAIN\username`,
+		"username@domain",
+		"username only",
+	}
+	configureCmd = &cobra.Command{
+		Use:   "configure",
+		Short: "Interactively sets up the IdP and defaults for a profile in the configuration file.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				return fmt.Errorf("loading configuration file: %w", err)
+			}
+			if err := configure(cmd.Context(), &cfg); err != nil {
+				return err
+			}
+			fmt.Printf("\nConfiguration written to %s\n", configPath)
+			return nil
+		},
+	}
+)
+
+func init() {
+	configureCmd.Flags().StringVarP(&cli.Profile, "profile", "", config.DefaultProfile, "The AWS profile to configure, the default profile sets the defaults for every profile.")
+	configureCmd.Flags().StringVarP(&cli.CABundle, "ca-bundle", "", "", "Path to your CA bundle to authenticate with ADFS.")
+	configureCmd.Flags().StringVarP(&configPath, "config", "", configPath, "Path to the aws-adfs-login configuration file.")
+	rootCmd.AddCommand(configureCmd)
+}
+
+// Walks the user through the settings of the profile, starting from the current
+// configuration, and saves the result.
+func configure(ctx context.Context, cfg *config.Config) error {
+	current, _ := config.Resolve(cfg.Layers(cli.Profile, ""))
+	fmt.Printf("Configuring profile %s in %s\n\n", cli.Profile, configPath)
+
+	idpUrl := current.IdpUrl
+	for {
+		var err error
+		if idpUrl, err = prompts.Text("IdP entry URL", idpUrl, required("IdP entry URL")); err != nil {
+			return err
+		}
+		probe, err := saml.Probe(ctx, idpUrl, cli.CABundle)
+		if err == nil && probe.Adfs {
+			fmt.Printf("Found the ADFS sign-in form at %s\n", probe.Url)
+			break
+		}
+		if err == nil {
+			fmt.Printf("Found a sign-in form at %s, but it doesn't look like ADFS\n", probe.Url)
+		} else {
+			fmt.Printf("Unable to find a sign-in form at %s -- %v\n", idpUrl, err)
+		}
+		if ok, err := prompts.Confirm("Use this URL anyway"); err != nil {
+			return err
+		} else if ok {
+			break
+		}
+	}
+	idp, _ := config.Resolve(cfg.Layers(cli.Profile, idpUrl))
+
+	format := 0
+	for i, f := range saml.UsernameFormats {
+		if f == idp.UsernameFormat {
+			format = i
+		}
+	}
+	format, err := prompts.Choice("Username format", usernameFormatOptions, format)
+	if err != nil {
+		return err
+	}
+	idpSettings := config.Settings{UsernameFormat: saml.UsernameFormats[format]}
+	if idpSettings.Username, err = prompts.Text("Username (leave empty to be prompted)", idp.Username, nil); err != nil {
+		return err
+	}
+	if idpSettings.UsernameFormat != saml.UsernameFormatPlain {
+		if idpSettings.Domain, err = prompts.Text("Domain", idp.Domain, required("Domain")); err != nil {
+			return err
+		}
+	}
+
+	region := current.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	duration := strconv.Itoa(current.Duration)
+	if current.Duration == 0 {
+		duration = strconv.Itoa(defaultDuration)
+	}
+	profileSettings := config.Settings{IdpUrl: idpUrl}
+	if profileSettings.Region, err = prompts.Text("Default region", region, validRegion); err != nil {
+		return err
+	}
+	if duration, err = prompts.Text("Session duration in seconds", duration, validDuration); err != nil {
+		return err
+	}
+	profileSettings.Duration, _ = strconv.Atoi(duration)
+
+	cfg.MergeIdp(idpUrl, idpSettings)
+	cfg.Merge(cli.Profile, profileSettings)
+	if err := config.Save(configPath, *cfg); err != nil {
+		return fmt.Errorf("writing configuration file: %w", err)
+	}
+	return nil
+}
+
+func required(label string) func(string) error {
+	return func(input string) error {
+		if input == "" {
+			return errors.New(label + " is required")
+		}
+		return nil
+	}
+}
+
+func validRegion(input string) error {
+	if !regionPattern.MatchString(input) {
+		return errors.New("expected a region such as us-east-1")
+	}
+	return nil
+}
+
+func validDuration(input string) error {
+	seconds, err := strconv.Atoi(input)
+	if err != nil || seconds < 900 || seconds > 43200 {
+		return errors.New("expected between 900 and 43200 seconds")
+	}
+	return nil
+}
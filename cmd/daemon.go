@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/auth"
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	socketPath    = config.DefaultSocketPath()
+	refreshWindow = auth.DefaultRefreshWindow
+	daemonCmd     = &cobra.Command{
+		Use:   "daemon",
+		Short: "Keeps every profile in the configuration file fresh, refreshing the credentials before they expire.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli.Logger = loggingConfig()
+			if err := parseDuration(); err != nil {
+				return err
+			}
+			if err := applyPasswordFlag(cmd); err != nil {
+				return err
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := applySettings(cmd, cfg, ""); err != nil {
+				return err
+			}
+			if err := requireRegion(); err != nil {
+				return err
+			}
+			if len(cfg.Profiles) == 0 {
+				return errors.New("loading configuration file: no profiles defined in " + configPath)
+			}
+			daemon := auth.NewDaemon(cli, cfg.Profiles)
+			daemon.RefreshWindow = refreshWindow
+
+			listener, err := auth.ListenDaemonSocket(socketPath)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(socketPath)
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGTERM)
+			defer stop()
+			go func() {
+				if err := daemon.Serve(ctx, listener); err != nil {
+					cli.Logger.Errorf("The control socket stopped -- %v", err)
+				}
+			}()
+			return daemon.Run(ctx)
+		},
+	}
+	daemonStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Prints the credentials expiry of each profile kept fresh by the daemon.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			response, err := auth.DaemonRequest(cmd.Context(), socketPath, auth.DaemonStatus)
+			if err != nil {
+				return err
+			}
+			printDaemonStatus(response)
+			if response.Error != "" {
+				fmt.Printf("Last refresh failed: %s\n", response.Error)
+			}
+			return nil
+		},
+	}
+	daemonRefreshCmd = &cobra.Command{
+		Use:   "refresh",
+		Short: "Asks the daemon to refresh every profile now.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			response, err := auth.DaemonRequest(cmd.Context(), socketPath, auth.DaemonRefresh)
+			if err != nil {
+				return err
+			}
+			printDaemonStatus(response)
+			if response.Error != "" {
+				return errors.New("refreshing the profiles: " + response.Error)
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	addLoginFlags(daemonCmd, true)
+	daemonCmd.Flags().IntVarP(&cli.Parallelism, "parallel", "", 4, "The number of roles assumed concurrently.")
+	daemonCmd.Flags().DurationVarP(&refreshWindow, "refresh-window", "", refreshWindow, "How long before the credentials expire they are refreshed.")
+	daemonCmd.PersistentFlags().StringVarP(&socketPath, "socket", "", socketPath, "Path to the daemon control socket.")
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(daemonRefreshCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
+
+func printDaemonStatus(response auth.DaemonResponse) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tROLE\tSTATUS")
+	for _, profile := range response.Profiles {
+		status := "ok, expires " + profile.Expiration.Local().Format(time.RFC1123)
+		if profile.Error != "" {
+			status = "failed: " + profile.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", profile.Profile, profile.Role, status)
+	}
+	w.Flush()
+	if !response.NextRefresh.IsZero() {
+		fmt.Printf("\nNext refresh at %s\n", response.NextRefresh.Local().Format(time.RFC1123))
+	}
+}
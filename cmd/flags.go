@@ -0,0 +1,33 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+// Registers the flags of the commands that sign in. Commands refreshing the
+// profiles of the configuration file only apply the region and duration to the
+// profiles that don't set their own.
+func addLoginFlags(cmd *cobra.Command, profiles bool) {
+	regionUsage := "The AWS region."
+	durationUsage := "The duration of your STS credentials in seconds, or \"max\" for the longest session the IdP allows."
+	if profiles {
+		regionUsage = "The AWS region of STS and of the profiles that don't set one."
+		durationUsage = "The duration of your STS credentials in seconds for profiles that don't set one, or \"max\" for the longest session the IdP allows."
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&debug, "debug", "d", false, "Enable debug logging.")
+	flags.StringVarP(&cli.Region, "region", "r", "", regionUsage)
+	flags.StringVarP(&cli.IdpEntryUrl, "idpEntryUrl", "i", "", "The IDP Entry URL for your ADFS environment.")
+	flags.StringVarP(&cli.CABundle, "ca-bundle", "", "", "Path to your CA bundle to authenticate with ADFS.")
+	flags.StringVarP(&duration, "duration", "", "900", durationUsage)
+	flags.StringVarP(&cli.User.Username, "username", "u", "", "Your login username")
+	flags.StringVarP(&passwordFlag, "password", "p", "", "Your login password, refused unless --insecure-password-flag is set as it is visible to other users.")
+	flags.BoolVarP(&insecurePasswordFlag, "insecure-password-flag", "", false, "Allow --password on the command line.")
+	flags.BoolVarP(&cli.PasswordStdin, "password-stdin", "", false, "Read your login password from stdin.")
+	flags.StringVarP(&cli.PasswordFile, "password-file", "", "", "Read your login password from a file, such as /dev/fd/3.")
+	flags.StringVarP(&cli.User.Domain, "domain", "", "", "Your login ADFS domain.")
+	flags.StringVarP(&cli.UsernameFormat, "username-format", "", "", "How the username is submitted: domain (DOMAIN\\username, the default), upn (username@domain) or plain.")
+	flags.StringVarP(&keyringBackend, "keyring", "", "", "The keyring holding the password: secret-service, pass, file or none. Defaults to the first available of secret-service and pass.")
+	flags.BoolVarP(&cli.ValidateAssertion, "validate-assertion", "", false, "Verify the SAML assertion signature, time window, audience and destination before use.")
+	flags.StringVarP(&cli.IdpCertificate, "idp-cert", "", "", "Path to the PEM encoded IdP token-signing certificate used to validate the SAML assertion.")
+	flags.StringVarP(&cli.IdpMetadata, "idp-metadata", "", "", "Path or https URL of the IdP FederationMetadata.xml used to validate the SAML assertion.")
+	flags.StringVarP(&configPath, "config", "", configPath, "Path to the aws-adfs-login configuration file.")
+}
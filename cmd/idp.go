@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/S7R4nG3/aws-adfs-login/saml"
+	"github.com/spf13/cobra"
+)
+
+var (
+	metadataRefresh = false
+	idpCmd          = &cobra.Command{
+		Use:   "idp",
+		Short: "Inspect the ADFS identity provider.",
+	}
+	idpInfoCmd = &cobra.Command{
+		Use:   "info",
+		Short: "Prints the identity provider details from its federation metadata.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger := loggingConfig()
+			location := cli.IdpMetadata
+			if location == "" {
+				var err error
+				location, err = saml.MetadataUrl(cli.IdpEntryUrl)
+				if err != nil {
+					return fmt.Errorf("locating federation metadata: %w", err)
+				}
+			}
+			cache := saml.NewMetadataCache(cli.CABundle, logger)
+			cache.Refresh = metadataRefresh
+			cache.AllowStale = true
+			metadata, err := cache.Load(cmd.Context(), location)
+			if err != nil {
+				return fmt.Errorf("loading federation metadata: %w", err)
+			}
+			printMetadata(metadata)
+			return nil
+		},
+	}
+)
+
+func init() {
+	idpInfoCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging.")
+	idpInfoCmd.Flags().StringVarP(&cli.IdpEntryUrl, "idpEntryUrl", "i", "", "The IDP Entry URL for your ADFS environment.")
+	idpInfoCmd.Flags().StringVarP(&cli.IdpMetadata, "idp-metadata", "", "", "Path or https URL of the IdP FederationMetadata.xml, discovered from the IDP Entry URL if omitted.")
+	idpInfoCmd.Flags().StringVarP(&cli.CABundle, "ca-bundle", "", "", "Path to your CA bundle to authenticate with ADFS.")
+	idpInfoCmd.Flags().BoolVarP(&metadataRefresh, "refresh", "", false, "Ignore the cached metadata and fetch it again.")
+	idpCmd.AddCommand(idpInfoCmd)
+	rootCmd.AddCommand(idpCmd)
+}
+
+func printMetadata(metadata *saml.FederationMetadata) {
+	fmt.Printf("Display Name:  %s\n", metadata.DisplayName)
+	fmt.Printf("Entity ID:     %s\n", metadata.EntityID)
+	fmt.Printf("Metadata:      %s\n", metadata.Location)
+	fmt.Printf("Retrieved:     %s\n", metadata.FetchedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("Sign-on URL:   %s\n", metadata.SignOnUrl())
+	fmt.Println("\nSingle Sign-On Endpoints:")
+	for _, endpoint := range metadata.SingleSignOnServices {
+		fmt.Printf("  %s  %s\n", endpoint.Location, endpoint.Binding)
+	}
+	fmt.Println("\nSingle Logout Endpoints:")
+	for _, endpoint := range metadata.SingleLogoutServices {
+		fmt.Printf("  %s  %s\n", endpoint.Location, endpoint.Binding)
+	}
+	fmt.Println("\nSigning Certificates:")
+	for _, cert := range metadata.SigningCertificates {
+		fmt.Printf("  Subject:     %s\n", cert.Subject)
+		fmt.Printf("  Expires:     %s\n", cert.NotAfter.Format("2006-01-02"))
+		fmt.Printf("  Fingerprint: %s\n", saml.Fingerprint(cert))
+	}
+}
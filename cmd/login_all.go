@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	loginAllCmd = &cobra.Command{
+		Use:   "login-all",
+		Short: "Refreshes every profile in the configuration file with a single login.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli.Logger = loggingConfig()
+			if err := parseDuration(); err != nil {
+				return err
+			}
+			if err := applyPasswordFlag(cmd); err != nil {
+				return err
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := applySettings(cmd, cfg, ""); err != nil {
+				return err
+			}
+			if err := requireRegion(); err != nil {
+				return err
+			}
+			if len(cfg.Profiles) == 0 {
+				return errors.New("loading configuration file: no profiles defined in " + configPath)
+			}
+			return cli.LoginAll(cmd.Context(), cfg.Profiles)
+		},
+	}
+)
+
+func init() {
+	addLoginFlags(loginAllCmd, true)
+	loginAllCmd.Flags().BoolVarP(&cli.SavePassword, "save-password", "", false, "Save the password to the keyring after a successful login.")
+	loginAllCmd.Flags().IntVarP(&cli.Parallelism, "parallel", "", 4, "The number of roles assumed concurrently.")
+	rootCmd.AddCommand(loginAllCmd)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/S7R4nG3/aws-adfs-login/keyring"
+	"github.com/S7R4nG3/aws-adfs-login/prompts"
+	"github.com/spf13/cobra"
+)
+
+var (
+	passwordFlag         = ""
+	insecurePasswordFlag = false
+	forgetPasswordCmd    = &cobra.Command{
+		Use:   "forget-password",
+		Short: "Removes the ADFS password saved in the keyring.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := applySettings(cmd, cfg, cli.Profile); err != nil {
+				return err
+			}
+			if cli.Keyring == nil {
+				return fmt.Errorf("opening keyring: %w", keyring.ErrUnavailable)
+			}
+			if cli.User.Username == "" {
+				if cli.User.Username, err = prompts.Username(); err != nil {
+					return err
+				}
+			}
+			account := keyring.Account(cli.IdpEntryUrl, cli.User.Domain, cli.User.Username)
+			err = cli.Keyring.Delete(account)
+			if errors.Is(err, keyring.ErrNotFound) {
+				fmt.Printf("No password saved for %s\n", account)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("removing password from keyring: %w", err)
+			}
+			fmt.Printf("Password for %s removed from the keyring.\n", account)
+			return nil
+		},
+	}
+)
+
+func init() {
+	forgetPasswordCmd.Flags().StringVarP(&cli.Profile, "profile", "", "default", "The name of your AWS credentials profile.")
+	forgetPasswordCmd.Flags().StringVarP(&cli.IdpEntryUrl, "idpEntryUrl", "i", "", "The IDP Entry URL for your ADFS environment.")
+	forgetPasswordCmd.Flags().StringVarP(&cli.User.Username, "username", "u", "", "Your login username")
+	forgetPasswordCmd.Flags().StringVarP(&cli.User.Domain, "domain", "", "", "Your login ADFS domain.")
+	forgetPasswordCmd.Flags().StringVarP(&keyringBackend, "keyring", "", "", "The keyring the password is saved in: secret-service, pass or file.")
+	forgetPasswordCmd.Flags().StringVarP(&configPath, "config", "", configPath, "Path to the aws-adfs-login configuration file.")
+	rootCmd.AddCommand(forgetPasswordCmd)
+}
+
+// Accepts --password only with --insecure-password-flag, as command line arguments
+// are visible to other users through ps.
+func applyPasswordFlag(cmd *cobra.Command) error {
+	if !cmd.Flags().Changed("password") {
+		return nil
+	}
+	if !insecurePasswordFlag {
+		return errors.New("refusing --password: the password would be visible to other users, use --password-stdin, --password-file or AWS_PASSWORD instead, or pass --insecure-password-flag")
+	}
+	cli.User.Password = passwordFlag
+	return nil
+}
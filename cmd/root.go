@@ -1,12 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"os/signal"
+	"strconv"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/S7R4nG3/aws-adfs-login/auth"
+	"github.com/S7R4nG3/aws-adfs-login/config"
+	"github.com/S7R4nG3/aws-adfs-login/logging"
 	"github.com/S7R4nG3/aws-adfs-login/types"
 	"github.com/spf13/cobra"
 )
@@ -17,18 +23,65 @@ const (
 	cmdLong  = `
 This simple login utility provides an easy interface for end users to login.
 Provide a few simple arguments/environment variables and you'll be off and rolling!`
+	// Session duration in seconds used when neither a flag nor the configuration sets one
+	defaultDuration = 900
 )
 
+// Process exit codes for each kind of login failure, any other error exits with 1.
+const (
+	exitError = 1 + iota
+	exitNetwork
+	exitAuthFailed
+	exitNoRoles
+	exitStsDenied
+	exitWriteFailed
+)
+
+var exitCodes = []struct {
+	kind error
+	code int
+}{
+	{types.ErrNetwork, exitNetwork},
+	{types.ErrAuthFailed, exitAuthFailed},
+	{types.ErrNoRoles, exitNoRoles},
+	{types.ErrStsDenied, exitStsDenied},
+	{types.ErrWriteFailed, exitWriteFailed},
+}
+
 var (
-	debug   = false
-	cli     = auth.CLI{}
-	rootCmd = &cobra.Command{
+	debug      = false
+	duration   = "900"
+	configPath = config.DefaultPath()
+	chainRoles []string
+	// Only read through the keyring setting, see applySettings
+	keyringBackend string
+	cli            = auth.CLI{}
+	rootCmd        = &cobra.Command{
 		Use:   "aws-login",
 		Short: cmdShort,
 		Long:  cmdLong,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			cli.Logger = loggingConfig()
-			cli.Login()
+			if err := parseDuration(); err != nil {
+				return err
+			}
+			if err := parseChainRoles(); err != nil {
+				return err
+			}
+			if err := applyPasswordFlag(cmd); err != nil {
+				return err
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := applySettings(cmd, cfg, cli.Profile); err != nil {
+				return err
+			}
+			if err := requireRegion(); err != nil {
+				return err
+			}
+			return cli.Login(cmd.Context())
 		},
 	}
 	versionCmd = &cobra.Command{
@@ -42,28 +95,98 @@ var (
 )
 
 func init() {
-	rootCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging.")
-	rootCmd.Flags().StringVarP(&cli.Region, "region", "r", "", "The AWS region (required).")
-	rootCmd.Flags().StringVarP(&cli.IdpEntryUrl, "idpEntryUrl", "i", "", "The IDP Entry URL for your ADFS environment.")
-	rootCmd.Flags().StringVarP(&cli.CABundle, "ca-bundle", "", "", "Path to your CA bundle to authenticate with ADFS.")
+	addLoginFlags(rootCmd, false)
 	rootCmd.Flags().StringVarP(&cli.Profile, "profile", "", "default", "The name of your AWS credentials profile.")
-	rootCmd.Flags().IntVarP(&cli.Duration, "duration", "", 900, "The duration of your STS credentials.")
-	rootCmd.Flags().StringVarP(&types.LoginUser.Username, "username", "u", "", "Your login username")
-	rootCmd.Flags().StringVarP(&types.LoginUser.Password, "password", "p", "", "Your login password - Please don't leave this in plaintext, use an environment variable...")
-	rootCmd.Flags().StringVarP(&types.LoginUser.Domain, "domain", "", "", "Your login ADFS domain.")
-	rootCmd.MarkFlagRequired("region")
-	rootCmd.MarkFlagRequired("idpEntryUrl")
+	rootCmd.Flags().BoolVarP(&cli.SavePassword, "save-password", "", false, "Save the password to the keyring after a successful login.")
+	rootCmd.Flags().StringVarP(&cli.UsernameField, "username-field", "", "", "Override the name of the login form's username input.")
+	rootCmd.Flags().StringVarP(&cli.PasswordField, "password-field", "", "", "Override the name of the login form's password input.")
+	rootCmd.Flags().StringVarP(&cli.FormSelector, "form-selector", "", "", "Select the login form by id (#id), name, or action when the portal has several forms.")
+	rootCmd.Flags().StringVarP(&cli.RoleFilter, "role", "", "", "The role to assume as a full ARN, role name, or glob pattern, skipping the role selection.")
+	rootCmd.Flags().StringVarP(&cli.AccountFilter, "account", "", "", "Limit the roles to an account ID or alias.")
+	rootCmd.Flags().BoolVarP(&cli.UseLast, "last", "", false, "Reuse the last selected role for this profile and IdP when it is still available.")
+	rootCmd.Flags().BoolVarP(&cli.ResolveAliases, "resolve-aliases", "", false, "Resolve account aliases for the role selection by posting the SAML assertion to the AWS sign-in page.")
+	rootCmd.Flags().BoolVarP(&cli.MultiSelect, "multiple", "m", false, "Select several roles and write a profile for each.")
+	rootCmd.Flags().StringToStringVarP(&cli.RoleMap, "role-map", "", nil, "Roles to log into and the profile to write each to, as role=profile pairs.")
+	rootCmd.Flags().IntVarP(&cli.Parallelism, "parallel", "", 4, "The number of roles assumed concurrently when logging into several roles.")
+	rootCmd.Flags().StringArrayVarP(&chainRoles, "chain-role", "", nil, "A role ARN to assume after the SAML login, optionally followed by ,external_id=,mfa_serial= or ,session_name= settings. Repeat to hop through several roles.")
 	rootCmd.AddCommand(versionCmd)
+	// Failures are reported by Execute without the usage text
+	rootCmd.SilenceUsage = true
+	rootCmd.SilenceErrors = true
 }
 
-// Primary execution entrypoint for the CLI
+// Primary execution entrypoint for the CLI, exits with the code for the kind of
+// error when the command fails.
 func Execute() {
-	rootCmd.Execute()
+	// Interrupting cancels the requests in flight rather than killing the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// The process exit code for the error, see types.LoginError.
+func exitCode(err error) int {
+	for _, c := range exitCodes {
+		if errors.Is(err, c.kind) {
+			return c.code
+		}
+	}
+	return exitError
+}
+
+// Accepts either a number of seconds or "max" for the duration flag, the number of
+// seconds is applied with the other settings.
+func parseDuration() error {
+	if duration == "max" {
+		cli.DurationMax = true
+		return nil
+	}
+	if _, err := strconv.Atoi(duration); err != nil {
+		return fmt.Errorf("invalid --duration, expected a number of seconds or \"max\": %w", err)
+	}
+	return nil
+}
+
+// Parses the --chain-role values in the order given.
+func parseChainRoles() error {
+	for _, value := range chainRoles {
+		role, err := auth.ParseChainRole(value)
+		if err != nil {
+			return fmt.Errorf("invalid --chain-role: %w", err)
+		}
+		cli.ChainRoles = append(cli.ChainRoles, role)
+	}
+	return nil
+}
+
+// The region of the STS endpoint, from the flags, environment or configuration.
+func requireRegion() error {
+	if cli.Region == "" {
+		return errors.New("missing AWS region: set --region, AWS_REGION or region in the configuration")
+	}
+	return nil
+}
+
+// Loads the configuration file and applies it to the CLI.
+func loadConfig() (config.Config, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return cfg, fmt.Errorf("loading configuration file: %w", err)
+	}
+	cfg.AwsProfiles, err = config.LoadAwsConfig(config.DefaultAwsConfigPath())
+	if err != nil {
+		return cfg, fmt.Errorf("loading AWS config file: %w", err)
+	}
+	cli.AccountAliases = cfg.AccountAliases
+	cli.StatePath = config.DefaultStatePath()
+	return cfg, nil
 }
 
 func loggingConfig() *logrus.Logger {
-	logger := logrus.New()
-	log.SetOutput(logger.Writer())
+	logger := logging.New()
 	logger.SetLevel(logrus.ErrorLevel)
 	if debug {
 		logger.SetLevel(logrus.DebugLevel)
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/S7R4nG3/aws-adfs-login/saml"
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/sirupsen/logrus"
+)
+
+func Test_Exit_Code(t *testing.T) {
+	unavailable := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: unavailable.Certificate().Raw}), 0600)
+	cache := saml.NewMetadataCache(caBundle, logrus.New())
+	cache.Dir = t.TempDir()
+	_, metadataErr := cache.Load(context.Background(), unavailable.URL+"/FederationMetadata.xml")
+
+	noFields := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`<html><body><form action="/adfs/ls/"><input type="text" name="q"/></form></body></html>`))
+	}))
+	defer noFields.Close()
+	portal := saml.Saml{IdpEntryUrl: noFields.URL, Logger: logrus.New()}
+	fieldsErr := portal.Verify(context.Background())
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "Validate errors without a kind exit with the generic code",
+			err:  errors.New("loading configuration file: no profiles defined"),
+			want: exitError,
+		},
+		{
+			name: "Validate wrapped login errors exit with the code of their kind",
+			err:  fmt.Errorf("logging into AWS roles: %w", types.NewError(types.ErrStsDenied, "", errors.New("AccessDenied"))),
+			want: exitStsDenied,
+		},
+		{
+			name: "Validate a federation metadata error status is a network failure",
+			err:  metadataErr,
+			want: exitNetwork,
+		},
+		{
+			name: "Validate a login portal without credential fields is an authentication failure",
+			err:  fieldsErr,
+			want: exitAuthFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v (%v)", got, tt.want, tt.err)
+		}
+	}
+}
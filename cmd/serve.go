@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/S7R4nG3/aws-adfs-login/auth"
+	"github.com/spf13/cobra"
+)
+
+// The environment variable the AWS SDKs read the authorization token from
+const tokenEnv = "AWS_CONTAINER_AUTHORIZATION_TOKEN"
+
+var (
+	listenAddress = "127.0.0.1:9911"
+	serveCmd      = &cobra.Command{
+		Use:   "serve",
+		Short: "Serves the credentials of every profile in the configuration file as a local ECS container credentials endpoint.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli.Logger = loggingConfig()
+			if err := parseDuration(); err != nil {
+				return err
+			}
+			if err := applyPasswordFlag(cmd); err != nil {
+				return err
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := applySettings(cmd, cfg, ""); err != nil {
+				return err
+			}
+			if err := requireRegion(); err != nil {
+				return err
+			}
+			if len(cfg.Profiles) == 0 {
+				return errors.New("loading configuration file: no profiles defined in " + configPath)
+			}
+			token, err := authorizationToken()
+			if err != nil {
+				return err
+			}
+			server := auth.NewCredentialServer(cli, cfg.Profiles, token)
+			server.RefreshWindow = refreshWindow
+
+			listener, err := auth.ListenLoopback(listenAddress)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Serving credentials on http://%s%s<profile>, point the AWS SDKs at a profile with:\n\n", listener.Addr(), auth.ProfilesPath)
+			fmt.Printf("export AWS_CONTAINER_CREDENTIALS_FULL_URI=http://%s%s%s\n", listener.Addr(), auth.ProfilesPath, cfg.Profiles[0].Name)
+			if _, ok := os.LookupEnv(tokenEnv); !ok {
+				fmt.Printf("export %s=%s\n", tokenEnv, token)
+			}
+			fmt.Println()
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGTERM)
+			defer stop()
+			return server.Serve(ctx, listener)
+		},
+	}
+)
+
+func init() {
+	addLoginFlags(serveCmd, true)
+	serveCmd.Flags().DurationVarP(&refreshWindow, "refresh-window", "", refreshWindow, "How long before the credentials expire they are refreshed.")
+	serveCmd.Flags().StringVarP(&listenAddress, "listen", "", listenAddress, "The loopback address and port to listen on.")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// The token requests must carry, AWS_CONTAINER_AUTHORIZATION_TOKEN or a random one.
+func authorizationToken() (string, error) {
+	if token, ok := os.LookupEnv(tokenEnv); ok {
+		if token == "" {
+			return "", errors.New("reading the authorization token: " + tokenEnv + " is empty")
+		}
+		return token, nil
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("generating the authorization token: %w", err)
+	}
+	return hex.EncodeToString(random), nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns the AWS shared config path, AWS_CONFIG_FILE or ~/.aws/config
+func DefaultAwsConfigPath() string {
+	if path, ok := os.LookupEnv("AWS_CONFIG_FILE"); ok {
+		return path
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ".aws", "config")
+}
+
+// Loads the login settings of each profile in the AWS shared config file from its
+// region and adfs_* keys, such as adfs_idp_url and adfs_role_arn. A missing file
+// results in no profiles.
+func LoadAwsConfig(path string) (map[string]Settings, error) {
+	profiles := map[string]Settings{}
+	if path == "" {
+		return profiles, nil
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return profiles, nil
+	}
+	if err != nil {
+		return profiles, err
+	}
+	defer file.Close()
+
+	profile := ""
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			profile = strings.TrimSpace(strings.TrimPrefix(strings.Trim(text, "[]"), "profile "))
+			continue
+		}
+		key, value, found := strings.Cut(text, "=")
+		if !found || profile == "" {
+			continue
+		}
+		settings := profiles[profile]
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "region":
+			settings.Region = value
+		case "adfs_idp_url":
+			settings.IdpUrl = value
+		case "adfs_role_arn":
+			settings.RoleArn = value
+		case "adfs_username":
+			settings.Username = value
+		case "adfs_domain":
+			settings.Domain = value
+		case "adfs_username_format":
+			settings.UsernameFormat = value
+		case "adfs_ca_bundle":
+			settings.CABundle = value
+		case "adfs_keyring":
+			settings.Keyring = value
+		case "adfs_duration":
+			settings.Duration, err = strconv.Atoi(value)
+			if err != nil {
+				return profiles, fmt.Errorf("%s line %d: invalid adfs_duration %q", path, line, value)
+			}
+		default:
+			continue
+		}
+		profiles[profile] = settings
+	}
+	return profiles, scanner.Err()
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Load_Aws_Config(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]Settings
+		wantErr bool
+	}{
+		{
+			name: "Validate the adfs settings and region are loaded per profile",
+			content: `
+[default]
+region = us-east-1
+adfs_idp_url = https://adfs.example
+
+# Workload account
+[profile prod]
+region=eu-west-1
+output = json
+adfs_role_arn = arn:aws:iam::123456789123:role/ReadOnly
+adfs_username = jdoe
+adfs_domain = CORP
+adfs_ca_bundle = /etc/ssl/corp.pem
+adfs_duration = 3600
+
+[profile other]
+output = json
+`,
+			want: map[string]Settings{
+				"default": {Region: "us-east-1", IdpUrl: "https://adfs.example"},
+				"prod": {
+					Region:   "eu-west-1",
+					RoleArn:  "arn:aws:iam::123456789123:role/ReadOnly",
+					Username: "jdoe",
+					Domain:   "CORP",
+					CABundle: "/etc/ssl/corp.pem",
+					Duration: 3600,
+				},
+			},
+		},
+		{
+			name:    "Validate a missing file has no profiles",
+			content: "",
+			want:    map[string]Settings{},
+		},
+		{
+			name: "Validate an invalid duration is an error",
+			content: `
+[profile prod]
+adfs_duration = max
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		path := filepath.Join(dir, "missing")
+		if tt.content != "" {
+			path = filepath.Join(dir, "config")
+			os.WriteFile(path, []byte(tt.content), 0600)
+		}
+		got, err := LoadAwsConfig(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configDir  = "aws-adfs-login"
+	configFile = "config.yaml"
+	// The AWS profile whose settings are the configuration defaults
+	DefaultProfile = "default"
+)
+
+// The user maintained configuration file.
+type Config struct {
+	// Friendly names for AWS account IDs, used when the AWS sign-in page
+	// can't provide the account alias.
+	AccountAliases map[string]string `yaml:"account_aliases,omitempty"`
+	// Settings used by every profile and IdP.
+	Defaults Settings `yaml:"defaults,omitempty"`
+	// Settings for an IdP keyed by its entry URL.
+	Idps map[string]Settings `yaml:"idps,omitempty"`
+	// Settings for named AWS profiles, also the profiles refreshed by login-all.
+	Profiles []Profile `yaml:"profiles,omitempty"`
+	// The adfs_* settings of the profiles in ~/.aws/config, see LoadAwsConfig.
+	AwsProfiles map[string]Settings `yaml:"-"`
+}
+
+// Login settings that can be given in the configuration instead of flags.
+type Settings struct {
+	IdpUrl         string `yaml:"idp_url,omitempty"`
+	Region         string `yaml:"region,omitempty"`
+	Duration       int    `yaml:"duration,omitempty"`
+	Username       string `yaml:"username,omitempty"`
+	Domain         string `yaml:"domain,omitempty"`
+	UsernameFormat string `yaml:"username_format,omitempty"`
+	CABundle       string `yaml:"ca_bundle,omitempty"`
+	RoleArn        string `yaml:"role_arn,omitempty"`
+	Keyring        string `yaml:"keyring,omitempty"`
+}
+
+// The settings of a named AWS profile.
+type Profile struct {
+	Name     string `yaml:"name"`
+	Settings `yaml:",inline"`
+}
+
+// Returns the default configuration path, ~/.config/aws-adfs-login/config.yaml
+func DefaultPath() string {
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ".config", configDir, configFile)
+}
+
+// Loads the configuration file at the given path. A missing file is not an
+// error and results in an empty configuration.
+func Load(path string) (Config, error) {
+	cfg := Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// Ensures every profile is named, and that no profile is defined twice.
+func (cfg Config) validate() error {
+	names := map[string]bool{}
+	for i, profile := range cfg.Profiles {
+		if profile.Name == "" {
+			return fmt.Errorf("profile %d requires a name", i+1)
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("profile %s is defined more than once", profile.Name)
+		}
+		names[profile.Name] = true
+	}
+	return nil
+}
+
+// Writes the configuration file, creating its directory when needed. The values
+// of an existing file are updated in place, keeping its comments and key order.
+func Save(path string, cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	var updated yaml.Node
+	if err := updated.Encode(cfg); err != nil {
+		return err
+	}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&updated}}
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var current yaml.Node
+	if err := yaml.Unmarshal(existing, &current); err != nil {
+		return err
+	}
+	if len(current.Content) > 0 {
+		mergeNode(&current, doc)
+		doc = &current
+	}
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+// Updates dst to the values of src while keeping the comments and order of dst.
+// Mapping keys missing from src are removed and new keys are appended, list
+// entries are matched by their name so the comments follow a profile.
+func mergeNode(dst *yaml.Node, src *yaml.Node) {
+	if dst.Kind != src.Kind {
+		head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+		*dst = *src
+		dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+		return
+	}
+	switch src.Kind {
+	case yaml.DocumentNode:
+		mergeNode(dst.Content[0], src.Content[0])
+	case yaml.MappingNode:
+		var content []*yaml.Node
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			if value := mappingValue(src, dst.Content[i].Value); value != nil {
+				mergeNode(dst.Content[i+1], value)
+				content = append(content, dst.Content[i], dst.Content[i+1])
+			}
+		}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			if mappingValue(dst, src.Content[i].Value) == nil {
+				content = append(content, src.Content[i], src.Content[i+1])
+			}
+		}
+		dst.Content = content
+	case yaml.SequenceNode:
+		content := make([]*yaml.Node, len(src.Content))
+		for i, item := range src.Content {
+			content[i] = item
+			for _, existing := range dst.Content {
+				if name := mappingValue(item, "name"); name != nil && existing.Kind == yaml.MappingNode {
+					if existingName := mappingValue(existing, "name"); existingName != nil && existingName.Value == name.Value {
+						mergeNode(existing, item)
+						content[i] = existing
+					}
+				}
+			}
+		}
+		dst.Content = content
+	default:
+		if dst.Value != src.Value || dst.Tag != src.Tag {
+			dst.Value, dst.Tag, dst.Style = src.Value, src.Tag, src.Style
+		}
+	}
+}
+
+// The value of the key in a mapping node, nil when it isn't set.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
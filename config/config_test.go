@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Load(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "Validate account aliases are loaded",
+			content: `
+account_aliases:
+  "123456789123": prod
+  "987654321321": dev
+`,
+			want: Config{
+				AccountAliases: map[string]string{
+					"123456789123": "prod",
+					"987654321321": "dev",
+				},
+			},
+		},
+		{
+			name: "Validate defaults and IdP settings are loaded",
+			content: `
+defaults:
+  idp_url: https://adfs.example
+  region: us-east-1
+  duration: 3600
+idps:
+  https://adfs.example:
+    username: jdoe
+    domain: CORP
+    ca_bundle: /etc/ssl/corp.pem
+`,
+			want: Config{
+				Defaults: Settings{IdpUrl: "https://adfs.example", Region: "us-east-1", Duration: 3600},
+				Idps: map[string]Settings{
+					"https://adfs.example": {Username: "jdoe", Domain: "CORP", CABundle: "/etc/ssl/corp.pem"},
+				},
+			},
+		},
+		{
+			name: "Validate profiles are loaded",
+			content: `
+profiles:
+  - name: dev
+    role_arn: arn:aws:iam::111111111111:role/Developer
+  - name: prod
+    role_arn: arn:aws:iam::123456789123:role/ReadOnly
+    region: eu-west-1
+    duration: 3600
+`,
+			want: Config{
+				Profiles: []Profile{
+					{Name: "dev", Settings: Settings{RoleArn: "arn:aws:iam::111111111111:role/Developer"}},
+					{Name: "prod", Settings: Settings{RoleArn: "arn:aws:iam::123456789123:role/ReadOnly", Region: "eu-west-1", Duration: 3600}},
+				},
+			},
+		},
+		{
+			name: "Validate a profile without a name is an error",
+			content: `
+profiles:
+  - role_arn: arn:aws:iam::111111111111:role/Developer
+`,
+			wantErr: true,
+		},
+		{
+			name: "Validate a profile defined twice is an error",
+			content: `
+profiles:
+  - name: dev
+    role_arn: arn:aws:iam::111111111111:role/Developer
+  - name: dev
+    role_arn: arn:aws:iam::111111111111:role/ReadOnly
+`,
+			wantErr: true,
+		},
+		{
+			name:    "Validate a missing file is an empty configuration",
+			content: "",
+			want:    Config{},
+		},
+		{
+			name:    "Validate invalid YAML is an error",
+			content: "account_aliases: [",
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		path := filepath.Join(dir, "missing.yaml")
+		if tt.content != "" {
+			path = filepath.Join(dir, fmt.Sprintf("config-%d.yaml", i))
+			os.WriteFile(path, []byte(tt.content), 0600)
+		}
+		got, err := Load(path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Test_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "config.yaml")
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{
+			name: "Validate a saved configuration loads unchanged",
+			cfg: Config{
+				AccountAliases: map[string]string{"123456789123": "prod"},
+				Defaults:       Settings{IdpUrl: "https://adfs.example", Region: "us-east-1", Duration: 3600},
+				Idps: map[string]Settings{
+					"https://adfs.example": {Username: "jdoe", Domain: "CORP", UsernameFormat: "upn"},
+				},
+				Profiles: []Profile{
+					{Name: "prod", Settings: Settings{RoleArn: "arn:aws:iam::123456789123:role/ReadOnly"}},
+				},
+			},
+		},
+		{
+			name:    "Validate an invalid configuration isn't saved",
+			cfg:     Config{Profiles: []Profile{{Settings: Settings{Region: "us-east-1"}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		err := Save(path, tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		got, err := Load(path)
+		if err != nil || !reflect.DeepEqual(got, tt.cfg) {
+			t.Errorf("Error running test -- got: %+v %v want: %+v", got, err, tt.cfg)
+		}
+	}
+}
+
+func Test_Save_Keeps_Comments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	existing := `# Login settings for the team
+profiles:
+  # Production, read only
+  - name: prod
+    role_arn: arn:aws:iam::123456789123:role/ReadOnly
+    region: eu-west-1 # closest region
+  - name: old
+    region: us-east-1
+defaults:
+  region: us-east-1
+  idp_url: https://adfs.example # the corporate IdP
+`
+	os.WriteFile(path, []byte(existing), 0600)
+	cfg, _ := Load(path)
+	cfg.Defaults.Region = "us-west-2"
+	cfg.Profiles = []Profile{cfg.Profiles[0], {Name: "dev", Settings: Settings{Region: "us-east-2"}}}
+	if err := Save(path, cfg); err != nil {
+		t.Fatalf("Error saving configuration -- %v", err)
+	}
+
+	want := `# Login settings for the team
+profiles:
+    # Production, read only
+    - name: prod
+      role_arn: arn:aws:iam::123456789123:role/ReadOnly
+      region: eu-west-1 # closest region
+    - name: dev
+      region: us-east-2
+defaults:
+    region: us-west-2
+    idp_url: https://adfs.example # the corporate IdP
+`
+	t.Logf("Running test -- %s", "Validate saving keeps the comments and key order of the file")
+	content, _ := os.ReadFile(path)
+	if string(content) != want {
+		t.Errorf("Error running test -- got: %v want: %v", string(content), want)
+	}
+	got, err := Load(path)
+	if err != nil || !reflect.DeepEqual(got, cfg) {
+		t.Errorf("Error running test -- got: %+v %v want: %+v", got, err, cfg)
+	}
+}
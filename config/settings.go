@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A named source of settings. Layers are merged in order, a value set in a
+// later layer overrides the earlier layers.
+type Layer struct {
+	Source   string
+	Settings Settings
+}
+
+// The configuration layers for a profile in increasing precedence: the defaults,
+// the IdP, the profile in ~/.aws/config and the profile in the configuration file.
+// The IdP is the given entry URL, or the one set by the profile or defaults.
+func (cfg Config) Layers(profile string, idpUrl string) []Layer {
+	var profileLayers []Layer
+	if settings, ok := cfg.AwsProfiles[profile]; ok {
+		profileLayers = append(profileLayers, Layer{Source: "aws config [" + profile + "]", Settings: settings})
+	}
+	for _, p := range cfg.Profiles {
+		if p.Name == profile {
+			profileLayers = append(profileLayers, Layer{Source: "profile " + profile, Settings: p.Settings})
+		}
+	}
+
+	layers := []Layer{{Source: "defaults", Settings: cfg.Defaults}}
+	if idpUrl == "" {
+		settings, _ := Resolve(append(layers, profileLayers...))
+		idpUrl = settings.IdpUrl
+	}
+	if settings, ok := cfg.Idps[idpUrl]; ok {
+		layers = append(layers, Layer{Source: "idp " + idpUrl, Settings: settings})
+	}
+	return append(layers, profileLayers...)
+}
+
+// Merges the layers and returns the effective settings along with the source of
+// each value, keyed by the setting name.
+func Resolve(layers []Layer) (Settings, map[string]string) {
+	var settings Settings
+	sources := map[string]string{}
+	merged := reflect.ValueOf(&settings).Elem()
+	keys := SettingKeys()
+	for _, layer := range layers {
+		values := reflect.ValueOf(layer.Settings)
+		for i := 0; i < values.NumField(); i++ {
+			if !values.Field(i).IsZero() {
+				merged.Field(i).Set(values.Field(i))
+				sources[keys[i]] = layer.Source
+			}
+		}
+	}
+	return settings, sources
+}
+
+// The setting names, as used in the configuration file, in field order.
+func SettingKeys() []string {
+	fields := reflect.TypeOf(Settings{})
+	keys := make([]string, fields.NumField())
+	for i := range keys {
+		keys[i] = strings.Split(fields.Field(i).Tag.Get("yaml"), ",")[0]
+	}
+	return keys
+}
+
+// Returns the value of a setting by name, empty when it isn't set.
+func (settings Settings) Get(key string) string {
+	for i, k := range SettingKeys() {
+		if k == key {
+			value := reflect.ValueOf(settings).Field(i)
+			if value.IsZero() {
+				return ""
+			}
+			return fmt.Sprint(value.Interface())
+		}
+	}
+	return ""
+}
+
+// Sets a setting by name from its string value.
+func (settings *Settings) Set(key string, value string) error {
+	for i, k := range SettingKeys() {
+		if k != key {
+			continue
+		}
+		field := reflect.ValueOf(settings).Elem().Field(i)
+		if field.Kind() == reflect.Int {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q, expected a number", key, value)
+			}
+			field.SetInt(int64(number))
+			return nil
+		}
+		field.SetString(value)
+		return nil
+	}
+	return fmt.Errorf("unknown setting %s", key)
+}
+
+// Merges the settings into the named profile, or into the defaults for the
+// default profile, adding the profile when it doesn't exist.
+func (cfg *Config) Merge(profile string, settings Settings) {
+	if profile == DefaultProfile {
+		cfg.Defaults, _ = Resolve([]Layer{{Settings: cfg.Defaults}, {Settings: settings}})
+		return
+	}
+	for i, p := range cfg.Profiles {
+		if p.Name == profile {
+			cfg.Profiles[i].Settings, _ = Resolve([]Layer{{Settings: p.Settings}, {Settings: settings}})
+			return
+		}
+	}
+	cfg.Profiles = append(cfg.Profiles, Profile{Name: profile, Settings: settings})
+}
+
+// Merges the settings into the settings of the IdP.
+func (cfg *Config) MergeIdp(idpUrl string, settings Settings) {
+	if cfg.Idps == nil {
+		cfg.Idps = map[string]Settings{}
+	}
+	cfg.Idps[idpUrl], _ = Resolve([]Layer{{Settings: cfg.Idps[idpUrl]}, {Settings: settings}})
+}
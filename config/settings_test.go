@@ -0,0 +1,221 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Resolve_Settings(t *testing.T) {
+	cfg := Config{
+		Defaults: Settings{
+			IdpUrl:   "https://adfs.example",
+			Region:   "us-east-1",
+			Duration: 3600,
+			Domain:   "CORP",
+		},
+		Idps: map[string]Settings{
+			"https://adfs.example":       {Username: "jdoe", CABundle: "/etc/ssl/corp.pem"},
+			"https://adfs-other.example": {Username: "other", Domain: "OTHER"},
+		},
+		Profiles: []Profile{
+			{Name: "prod", Settings: Settings{RoleArn: "arn:aws:iam::123456789123:role/ReadOnly", Region: "eu-west-1"}},
+		},
+		AwsProfiles: map[string]Settings{
+			"prod": {Region: "eu-central-1", Duration: 900},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		profile     string
+		idpUrl      string
+		overrides   []Layer
+		want        Settings
+		wantSources map[string]string
+	}{
+		{
+			name:    "Validate the defaults and IdP settings apply to an unknown profile",
+			profile: "default",
+			want: Settings{
+				IdpUrl:   "https://adfs.example",
+				Region:   "us-east-1",
+				Duration: 3600,
+				Username: "jdoe",
+				Domain:   "CORP",
+				CABundle: "/etc/ssl/corp.pem",
+			},
+			wantSources: map[string]string{
+				"idp_url":   "defaults",
+				"region":    "defaults",
+				"duration":  "defaults",
+				"username":  "idp https://adfs.example",
+				"domain":    "defaults",
+				"ca_bundle": "idp https://adfs.example",
+			},
+		},
+		{
+			name:    "Validate the profile overrides the AWS config which overrides the defaults",
+			profile: "prod",
+			want: Settings{
+				IdpUrl:   "https://adfs.example",
+				Region:   "eu-west-1",
+				Duration: 900,
+				Username: "jdoe",
+				Domain:   "CORP",
+				CABundle: "/etc/ssl/corp.pem",
+				RoleArn:  "arn:aws:iam::123456789123:role/ReadOnly",
+			},
+			wantSources: map[string]string{
+				"idp_url":   "defaults",
+				"region":    "profile prod",
+				"duration":  "aws config [prod]",
+				"username":  "idp https://adfs.example",
+				"domain":    "defaults",
+				"ca_bundle": "idp https://adfs.example",
+				"role_arn":  "profile prod",
+			},
+		},
+		{
+			name:    "Validate the given IdP selects its settings and later layers win",
+			profile: "prod",
+			idpUrl:  "https://adfs-other.example",
+			overrides: []Layer{
+				{Source: "env AWS_REGION", Settings: Settings{Region: "ap-southeast-2"}},
+				{Source: "flag", Settings: Settings{IdpUrl: "https://adfs-other.example", Region: "us-west-2"}},
+			},
+			want: Settings{
+				IdpUrl:   "https://adfs-other.example",
+				Region:   "us-west-2",
+				Duration: 900,
+				Username: "other",
+				Domain:   "OTHER",
+				RoleArn:  "arn:aws:iam::123456789123:role/ReadOnly",
+			},
+			wantSources: map[string]string{
+				"idp_url":  "flag",
+				"region":   "flag",
+				"duration": "aws config [prod]",
+				"username": "idp https://adfs-other.example",
+				"domain":   "idp https://adfs-other.example",
+				"role_arn": "profile prod",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got, sources := Resolve(append(cfg.Layers(tt.profile, tt.idpUrl), tt.overrides...))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+		if !reflect.DeepEqual(sources, tt.wantSources) {
+			t.Errorf("Error running test -- got: %v want: %v", sources, tt.wantSources)
+		}
+	}
+}
+
+func Test_Settings_Get_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Validate a string setting", key: "idp_url", value: "https://adfs.example", want: "https://adfs.example"},
+		{name: "Validate a number setting", key: "duration", value: "3600", want: "3600"},
+		{name: "Validate an invalid number is an error", key: "duration", value: "max", wantErr: true},
+		{name: "Validate an unknown setting is an error", key: "password", value: "cheese", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		var settings Settings
+		err := settings.Set(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if got := settings.Get(tt.key); !tt.wantErr && got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Test_Merge_Settings(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  string
+		idpUrl   string
+		settings Settings
+		idp      Settings
+		want     Config
+	}{
+		{
+			name:     "Validate the default profile updates the defaults",
+			profile:  DefaultProfile,
+			idpUrl:   "https://adfs.example",
+			settings: Settings{IdpUrl: "https://adfs.example", Region: "eu-west-1"},
+			idp:      Settings{Domain: "CORP", UsernameFormat: "domain"},
+			want: Config{
+				Defaults: Settings{IdpUrl: "https://adfs.example", Region: "eu-west-1", Duration: 3600},
+				Idps: map[string]Settings{
+					"https://adfs.example": {Username: "jdoe", Domain: "CORP", UsernameFormat: "domain"},
+				},
+				Profiles: []Profile{
+					{Name: "prod", Settings: Settings{RoleArn: "arn:aws:iam::123456789123:role/ReadOnly"}},
+				},
+			},
+		},
+		{
+			name:     "Validate an existing profile is updated and keeps its role",
+			profile:  "prod",
+			idpUrl:   "https://adfs-other.example",
+			settings: Settings{IdpUrl: "https://adfs-other.example", Duration: 900},
+			idp:      Settings{UsernameFormat: "plain"},
+			want: Config{
+				Defaults: Settings{Region: "us-east-1", Duration: 3600},
+				Idps: map[string]Settings{
+					"https://adfs.example":       {Username: "jdoe"},
+					"https://adfs-other.example": {UsernameFormat: "plain"},
+				},
+				Profiles: []Profile{
+					{Name: "prod", Settings: Settings{IdpUrl: "https://adfs-other.example", Duration: 900, RoleArn: "arn:aws:iam::123456789123:role/ReadOnly"}},
+				},
+			},
+		},
+		{
+			name:     "Validate a new profile is added",
+			profile:  "dev",
+			idpUrl:   "https://adfs.example",
+			settings: Settings{Region: "us-west-2"},
+			want: Config{
+				Defaults: Settings{Region: "us-east-1", Duration: 3600},
+				Idps: map[string]Settings{
+					"https://adfs.example": {Username: "jdoe"},
+				},
+				Profiles: []Profile{
+					{Name: "prod", Settings: Settings{RoleArn: "arn:aws:iam::123456789123:role/ReadOnly"}},
+					{Name: "dev", Settings: Settings{Region: "us-west-2"}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		cfg := Config{
+			Defaults: Settings{Region: "us-east-1", Duration: 3600},
+			Idps: map[string]Settings{
+				"https://adfs.example": {Username: "jdoe"},
+			},
+			Profiles: []Profile{
+				{Name: "prod", Settings: Settings{RoleArn: "arn:aws:iam::123456789123:role/ReadOnly"}},
+			},
+		}
+		cfg.MergeIdp(tt.idpUrl, tt.idp)
+		cfg.Merge(tt.profile, tt.settings)
+		if !reflect.DeepEqual(cfg, tt.want) {
+			t.Errorf("Error running test -- got: %+v want: %+v", cfg, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFile  = "state.json"
+	socketFile = "daemon.sock"
+	// The directory of the control socket under the configuration directory,
+	// kept apart as it must only be accessible to the user
+	socketDir = "run"
+)
+
+// Small amount of state remembered between logins.
+type State struct {
+	// The last selected role ARN keyed by IdP and profile, see StateKey.
+	LastRoles map[string]string `json:"last_roles"`
+}
+
+// Returns the default state path, ~/.config/aws-adfs-login/state.json
+func DefaultStatePath() string {
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ".config", configDir, stateFile)
+}
+
+// Returns the default control socket path of the refresh daemon,
+// $XDG_RUNTIME_DIR/aws-adfs-login/daemon.sock or, without a runtime directory,
+// ~/.config/aws-adfs-login/run/daemon.sock
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, configDir, socketFile)
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ".config", configDir, socketDir, socketFile)
+}
+
+// The key used to remember values per IdP and AWS profile.
+func StateKey(idpEntryUrl string, profile string) string {
+	return idpEntryUrl + "|" + profile
+}
+
+// Loads the state file at the given path. A missing file results in an empty state.
+func LoadState(path string) (State, error) {
+	state := State{LastRoles: map[string]string{}}
+	if path == "" {
+		return state, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, err
+	}
+	if state.LastRoles == nil {
+		state.LastRoles = map[string]string{}
+	}
+	return state, nil
+}
+
+// Writes the state file, creating its directory if needed.
+func (state State) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
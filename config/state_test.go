@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_State(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+	tests := []struct {
+		name    string
+		profile string
+		role    string
+		want    map[string]string
+	}{
+		{
+			name:    "Validate the last role is remembered for a profile",
+			profile: "default",
+			role:    "arn:aws:iam::123456789123:role/AdministratorAccess",
+			want: map[string]string{
+				"https://adfs.example|default": "arn:aws:iam::123456789123:role/AdministratorAccess",
+			},
+		},
+		{
+			name:    "Validate profiles are remembered separately",
+			profile: "dev",
+			role:    "arn:aws:iam::987654321321:role/DeveloperAccess",
+			want: map[string]string{
+				"https://adfs.example|default": "arn:aws:iam::123456789123:role/AdministratorAccess",
+				"https://adfs.example|dev":     "arn:aws:iam::987654321321:role/DeveloperAccess",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		state, err := LoadState(path)
+		if err != nil {
+			t.Errorf("Error running test -- %v", err)
+		}
+		state.LastRoles[StateKey("https://adfs.example", tt.profile)] = tt.role
+		if err := state.Save(path); err != nil {
+			t.Errorf("Error running test -- %v", err)
+		}
+		got, _ := LoadState(path)
+		if !reflect.DeepEqual(got.LastRoles, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got.LastRoles, tt.want)
+		}
+	}
+}
+
+func Test_Default_Socket_Path(t *testing.T) {
+	home, _ := os.UserHomeDir()
+	tests := []struct {
+		name       string
+		runtimeDir string
+		want       string
+	}{
+		{
+			name:       "Validate the socket is placed in the runtime directory",
+			runtimeDir: "/run/user/1000",
+			want:       filepath.Join("/run/user/1000", "aws-adfs-login", "daemon.sock"),
+		},
+		{
+			name: "Validate the socket has its own directory without a runtime directory",
+			want: filepath.Join(home, ".config", "aws-adfs-login", "run", "daemon.sock"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		t.Setenv("XDG_RUNTIME_DIR", tt.runtimeDir)
+		if got := DefaultSocketPath(); got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
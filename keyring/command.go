@@ -0,0 +1,122 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A keyring command that failed, with its exit status and what it wrote to stderr.
+type commandError struct {
+	name     string
+	exitCode int
+	stderr   string
+	err      error
+}
+
+func (e *commandError) Error() string {
+	if e.stderr != "" {
+		return "running " + e.name + ": " + e.stderr
+	}
+	return "running " + e.name + ": " + e.err.Error()
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+// Runs a keyring command with the input on stdin and returns its output, replaced in tests.
+var runCommand = func(input string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), &commandError{
+			name:     name,
+			exitCode: cmd.ProcessState.ExitCode(),
+			stderr:   strings.TrimSpace(stderr.String()),
+			err:      err,
+		}
+	}
+	return stdout.String(), nil
+}
+
+// The Linux Secret Service, through the secret-tool command from libsecret.
+type secretService struct{}
+
+func (secretService) Get(account string) (string, error) {
+	secret, err := runCommand("", "secret-tool", "lookup", "service", Service, "account", account)
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) && cmdErr.exitCode == 1 && cmdErr.stderr == "" && secret == "" {
+		// secret-tool exits with status 1 and no output for a missing secret
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(secret, "\n"), nil
+}
+
+func (secretService) Set(account string, secret string) error {
+	_, err := runCommand(secret, "secret-tool", "store", "--label", Service+" "+account, "service", Service, "account", account)
+	return err
+}
+
+func (secretService) Delete(account string) error {
+	if _, err := (secretService{}).Get(account); err != nil {
+		return err
+	}
+	_, err := runCommand("", "secret-tool", "clear", "service", Service, "account", account)
+	return err
+}
+
+// The pass password store, each account is an entry under aws-adfs-login/.
+type pass struct{}
+
+func passEntry(account string) string {
+	return Service + "/" + url.QueryEscape(account)
+}
+
+func passStoreExists() bool {
+	dir, ok := os.LookupEnv("PASSWORD_STORE_DIR")
+	if !ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+		dir = filepath.Join(home, ".password-store")
+	}
+	_, err := os.Stat(filepath.Join(dir, ".gpg-id"))
+	return err == nil
+}
+
+func (pass) Get(account string) (string, error) {
+	secret, err := runCommand("", "pass", "show", passEntry(account))
+	if err != nil {
+		if strings.Contains(err.Error(), "not in the password store") {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	// The first line holds the password
+	return strings.SplitN(secret, "\n", 2)[0], nil
+}
+
+func (pass) Set(account string, secret string) error {
+	_, err := runCommand(secret+"\n", "pass", "insert", "--multiline", "--force", passEntry(account))
+	return err
+}
+
+func (pass) Delete(account string) error {
+	_, err := runCommand("", "pass", "rm", "--force", passEntry(account))
+	if err != nil && strings.Contains(err.Error(), "not in the password store") {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// PBKDF2 iterations used to derive the file key, lowered in tests.
+var keyIterations = 600000
+
+// The contents of the encrypted keyring file.
+type encryptedFile struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Secrets kept in a file encrypted with AES-256-GCM, using a key derived from a
+// passphrase with PBKDF2-SHA256.
+type file struct {
+	path       string
+	passphrase func() (string, error)
+	mu         sync.Mutex
+	key        string
+}
+
+func (f *file) Get(account string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[account]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (f *file) Set(account string, secret string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	secrets[account] = secret
+	return f.save(secrets)
+}
+
+func (f *file) Delete(account string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[account]; !ok {
+		return ErrNotFound
+	}
+	delete(secrets, account)
+	return f.save(secrets)
+}
+
+// The passphrase, asked for once.
+func (f *file) secret() (string, error) {
+	if f.key != "" {
+		return f.key, nil
+	}
+	if f.passphrase == nil {
+		return "", errors.New("no keyring passphrase available")
+	}
+	key, err := f.passphrase()
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", errors.New("the keyring passphrase can't be empty")
+	}
+	f.key = key
+	return key, nil
+}
+
+func (f *file) load() (map[string]string, error) {
+	secrets := map[string]string{}
+	content, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var encrypted encryptedFile
+	if err := json.Unmarshal(content, &encrypted); err != nil {
+		return nil, fmt.Errorf("reading keyring file %s: %w", f.path, err)
+	}
+	passphrase, err := f.secret()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newCipher(passphrase, encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("decrypting the keyring file: wrong passphrase or corrupted file")
+	}
+	err = json.Unmarshal(plaintext, &secrets)
+	return secrets, err
+}
+
+func (f *file) save(secrets map[string]string) error {
+	passphrase, err := f.secret()
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	encrypted := encryptedFile{Salt: make([]byte, saltSize)}
+	if _, err := rand.Read(encrypted.Salt); err != nil {
+		return err
+	}
+	gcm, err := newCipher(passphrase, encrypted.Salt)
+	if err != nil {
+		return err
+	}
+	encrypted.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(encrypted.Nonce); err != nil {
+		return err
+	}
+	encrypted.Ciphertext = gcm.Seal(nil, encrypted.Nonce, plaintext, nil)
+	content, err := json.Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, content, 0600)
+}
+
+func newCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(fileKey(passphrase, salt, keyIterations))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Derives the file key from the passphrase with PBKDF2-HMAC-SHA256.
+func fileKey(passphrase string, salt []byte, iterations int) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, iterations, keySize, sha256.New)
+}
@@ -0,0 +1,91 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// The service name the secrets are stored under.
+const Service = "aws-adfs-login"
+
+// The keyring backends.
+const (
+	// The Linux Secret Service (GNOME Keyring, KWallet) through secret-tool
+	BackendSecretService = "secret-service"
+	// The pass password store
+	BackendPass = "pass"
+	// A passphrase encrypted file
+	BackendFile = "file"
+	// Disables the keyring
+	BackendNone = "none"
+)
+
+// The backends that can be chosen, an empty backend selects the first available of
+// the Secret Service and pass.
+var Backends = []string{BackendSecretService, BackendPass, BackendFile, BackendNone}
+
+// Returned when no secret is stored for the account.
+var ErrNotFound = errors.New("secret not found in keyring")
+
+// Returned when no backend is available or the keyring is disabled.
+var ErrUnavailable = errors.New("no keyring backend available")
+
+// Stores secrets, such as the ADFS password, per account.
+type Keyring interface {
+	Get(account string) (string, error)
+	Set(account string, secret string) error
+	Delete(account string) error
+}
+
+// Options for the file backend.
+type Options struct {
+	FilePath   string
+	Passphrase func() (string, error)
+}
+
+// Opens the keyring backend. An empty backend selects the Secret Service when
+// secret-tool is installed, then pass when it is installed and initialized.
+func Open(backend string, options Options) (Keyring, error) {
+	switch backend {
+	case "":
+		if _, err := exec.LookPath("secret-tool"); err == nil {
+			return secretService{}, nil
+		}
+		if _, err := exec.LookPath("pass"); err == nil && passStoreExists() {
+			return pass{}, nil
+		}
+		return nil, ErrUnavailable
+	case BackendSecretService:
+		return secretService{}, nil
+	case BackendPass:
+		return pass{}, nil
+	case BackendFile:
+		if options.FilePath == "" {
+			options.FilePath = DefaultFilePath()
+		}
+		return &file{path: options.FilePath, passphrase: options.Passphrase}, nil
+	case BackendNone:
+		return nil, ErrUnavailable
+	}
+	return nil, fmt.Errorf("unknown keyring backend %q, expected one of %v", backend, Backends)
+}
+
+// The account a password is stored under, the user at the IdP.
+func Account(idpUrl string, domain string, username string) string {
+	if domain != "" {
+		username = domain + `\` + username
+	}
+	return idpUrl + "|" + username
+}
+
+// Returns the default encrypted file path, ~/.config/aws-adfs-login/keyring
+func DefaultFilePath() string {
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ".config", Service, "keyring")
+}
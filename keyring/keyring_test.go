@@ -0,0 +1,182 @@
+package keyring
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_File_Key(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		salt       string
+		iterations int
+		want       string
+	}{
+		{
+			name:       "Validate the RFC 7914 single iteration vector",
+			password:   "passwd",
+			salt:       "salt",
+			iterations: 1,
+			want:       "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc",
+		},
+		{
+			name:       "Validate the RFC 7914 many iterations vector",
+			password:   "Password",
+			salt:       "NaCl",
+			iterations: 80000,
+			want:       "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got := hex.EncodeToString(fileKey(tt.password, []byte(tt.salt), tt.iterations))
+		if got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Test_Keyring_Backends(t *testing.T) {
+	keyIterations = 1000
+	path := filepath.Join(t.TempDir(), "nested", "keyring")
+	passphrase := func(p string) func() (string, error) {
+		return func() (string, error) { return p, nil }
+	}
+
+	tests := []struct {
+		name    string
+		keyring func() Keyring
+	}{
+		{
+			name:    "Validate the in-memory keyring",
+			keyring: func() Keyring { return NewMemory() },
+		},
+		{
+			name: "Validate the encrypted file keyring",
+			keyring: func() Keyring {
+				k, _ := Open(BackendFile, Options{FilePath: path, Passphrase: passphrase("correct horse")})
+				return k
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		k := tt.keyring()
+		account := Account("https://adfs.example", "CORP", "jdoe")
+		if _, err := k.Get(account); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Error running test -- got: %v want: %v", err, ErrNotFound)
+		}
+		if err := k.Set(account, "cheese"); err != nil {
+			t.Errorf("Error running test -- got: %v", err)
+		}
+		k.Set(Account("https://adfs.example", "", "other"), "crackers")
+		if got, err := k.Get(account); got != "cheese" || err != nil {
+			t.Errorf("Error running test -- got: %v %v want: cheese", got, err)
+		}
+		if err := k.Delete(account); err != nil {
+			t.Errorf("Error running test -- got: %v", err)
+		}
+		if err := k.Delete(account); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Error running test -- got: %v want: %v", err, ErrNotFound)
+		}
+		if got, _ := k.Get(Account("https://adfs.example", "", "other")); got != "crackers" {
+			t.Errorf("Error running test -- got: %v want: crackers", got)
+		}
+	}
+
+	wrong, _ := Open(BackendFile, Options{FilePath: path, Passphrase: passphrase("wrong")})
+	if _, err := wrong.Get("anything"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Error running test -- got: %v want: a decryption error", err)
+	}
+}
+
+func Test_Command_Backends(t *testing.T) {
+	defer func(original func(string, string, ...string) (string, error)) { runCommand = original }(runCommand)
+	unreachable := &commandError{name: "secret-tool", exitCode: 1, stderr: "Cannot autolaunch D-Bus without X11 $DISPLAY", err: errors.New("exit status 1")}
+	tests := []struct {
+		name    string
+		keyring Keyring
+		output  string
+		err     error
+		call    func(k Keyring) (string, error)
+		want    string
+		wantErr error
+		wantCmd string
+	}{
+		{
+			name:    "Validate a Secret Service lookup",
+			keyring: secretService{},
+			output:  "cheese\n",
+			call:    func(k Keyring) (string, error) { return k.Get("https://adfs.example|jdoe") },
+			want:    "cheese",
+			wantCmd: "secret-tool lookup service aws-adfs-login account https://adfs.example|jdoe <- ",
+		},
+		{
+			name:    "Validate a missing Secret Service secret",
+			keyring: secretService{},
+			err:     &commandError{name: "secret-tool", exitCode: 1, err: errors.New("exit status 1")},
+			call:    func(k Keyring) (string, error) { return k.Get("https://adfs.example|jdoe") },
+			wantErr: ErrNotFound,
+			wantCmd: "secret-tool lookup service aws-adfs-login account https://adfs.example|jdoe <- ",
+		},
+		{
+			name:    "Validate a Secret Service failure isn't reported as a missing secret",
+			keyring: secretService{},
+			err:     unreachable,
+			call:    func(k Keyring) (string, error) { return k.Get("https://adfs.example|jdoe") },
+			wantErr: unreachable,
+			wantCmd: "secret-tool lookup service aws-adfs-login account https://adfs.example|jdoe <- ",
+		},
+		{
+			name:    "Validate the Secret Service secret is stored from stdin",
+			keyring: secretService{},
+			call:    func(k Keyring) (string, error) { return "", k.Set("https://adfs.example|jdoe", "cheese") },
+			wantCmd: "secret-tool store --label aws-adfs-login https://adfs.example|jdoe service aws-adfs-login account https://adfs.example|jdoe <- cheese",
+		},
+		{
+			name:    "Validate a pass lookup uses the first line",
+			keyring: pass{},
+			output:  "cheese\nusername: jdoe\n",
+			call:    func(k Keyring) (string, error) { return k.Get("https://adfs.example|jdoe") },
+			want:    "cheese",
+			wantCmd: "pass show aws-adfs-login/https%3A%2F%2Fadfs.example%7Cjdoe <- ",
+		},
+		{
+			name:    "Validate a missing pass entry",
+			keyring: pass{},
+			err:     errors.New("Error: aws-adfs-login/x is not in the password store."),
+			call:    func(k Keyring) (string, error) { return "", k.Delete("https://adfs.example|jdoe") },
+			wantErr: ErrNotFound,
+			wantCmd: "pass rm --force aws-adfs-login/https%3A%2F%2Fadfs.example%7Cjdoe <- ",
+		},
+		{
+			name:    "Validate the pass entry is inserted from stdin",
+			keyring: pass{},
+			call:    func(k Keyring) (string, error) { return "", k.Set("https://adfs.example|jdoe", "cheese") },
+			wantCmd: "pass insert --multiline --force aws-adfs-login/https%3A%2F%2Fadfs.example%7Cjdoe <- cheese\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		var commands []string
+		runCommand = func(input string, name string, args ...string) (string, error) {
+			commands = append(commands, name+" "+strings.Join(args, " ")+" <- "+input)
+			return tt.output, tt.err
+		}
+		got, err := tt.call(tt.keyring)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("Error running test -- got: %v %v want: %v %v", got, err, tt.want, tt.wantErr)
+		}
+		if !reflect.DeepEqual(commands[:1], []string{tt.wantCmd}) {
+			t.Errorf("Error running test -- got: %v want: %v", commands, tt.wantCmd)
+		}
+	}
+}
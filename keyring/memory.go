@@ -0,0 +1,40 @@
+package keyring
+
+import "sync"
+
+// An in-memory keyring, used in tests.
+type Memory struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{secrets: map[string]string{}}
+}
+
+func (m *Memory) Get(account string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	secret, ok := m.secrets[account]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (m *Memory) Set(account string, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secrets[account] = secret
+	return nil
+}
+
+func (m *Memory) Delete(account string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.secrets[account]; !ok {
+		return ErrNotFound
+	}
+	delete(m.secrets, account)
+	return nil
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	redacted = "[REDACTED]"
+	// Shorter secrets would redact ordinary words
+	minimumSecretLength = 4
+)
+
+// Values of well known secret fields, such as the credentials file keys and
+// form encoded SAML responses and passwords.
+var secretFields = regexp.MustCompile(`(?i)((?:aws_secret_access_key|aws_session_token|SecretAccessKey|SessionToken|SAMLResponse|Password[A-Za-z]*)\s*[=:]\s*"?)[^\s&",}\]]+`)
+
+// A logrus formatter that removes registered secrets and well known secret
+// fields from the message and fields of every entry before formatting it.
+type Redactor struct {
+	Formatter logrus.Formatter
+	mu        sync.RWMutex
+	secrets   []string
+}
+
+// Creates a logger that redacts secrets from its output.
+func New() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetFormatter(&Redactor{Formatter: &logrus.TextFormatter{}})
+	return logger
+}
+
+// Registers a secret to redact from the logger output. Loggers that weren't
+// created by New are left unchanged.
+func AddSecret(logger *logrus.Logger, secret string) {
+	if logger == nil {
+		return
+	}
+	if redactor, ok := logger.Formatter.(*Redactor); ok {
+		redactor.Add(secret)
+	}
+}
+
+// Stops redacting a secret that is no longer in use, such as replaced temporary
+// credentials, so long running processes don't accumulate secrets. Loggers that
+// weren't created by New are left unchanged.
+func RemoveSecret(logger *logrus.Logger, secret string) {
+	if logger == nil {
+		return
+	}
+	if redactor, ok := logger.Formatter.(*Redactor); ok {
+		redactor.Remove(secret)
+	}
+}
+
+// Registers a secret to redact.
+func (r *Redactor) Add(secret string) {
+	if len(secret) < minimumSecretLength {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.secrets {
+		if s == secret {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, secret)
+}
+
+// Forgets a registered secret.
+func (r *Redactor) Remove(secret string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, s := range r.secrets {
+		if s == secret {
+			r.secrets = append(r.secrets[:i], r.secrets[i+1:]...)
+			return
+		}
+	}
+}
+
+// Replaces the secrets in the text.
+func (r *Redactor) Redact(text string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return secretFields.ReplaceAllString(text, "${1}"+redacted)
+}
+
+func (r *Redactor) Format(entry *logrus.Entry) ([]byte, error) {
+	clean := entry.Dup()
+	clean.Level = entry.Level
+	clean.Caller = entry.Caller
+	clean.Message = r.Redact(entry.Message)
+	for key, value := range clean.Data {
+		switch v := value.(type) {
+		case string:
+			clean.Data[key] = r.Redact(v)
+		case error:
+			clean.Data[key] = r.Redact(v.Error())
+		}
+	}
+	return r.Formatter.Format(clean)
+}
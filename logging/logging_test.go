@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func Test_Redactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		message string
+		fields  logrus.Fields
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "Registered secrets are redacted from the message...",
+			secrets: []string{"hunter22"},
+			message: "Logging in with hunter22",
+			want:    []string{"Logging in with [REDACTED]"},
+			notWant: []string{"hunter22"},
+		},
+		{
+			name:    "Registered secrets are redacted from the fields...",
+			secrets: []string{"hunter22"},
+			message: "Logging in",
+			fields:  logrus.Fields{"password": "hunter22", "err": errors.New("bad password hunter22")},
+			want:    []string{"Logging in", "[REDACTED]"},
+			notWant: []string{"hunter22"},
+		},
+		{
+			name:    "Secret fields are redacted without registering them...",
+			message: "Form data: UserName=domain\\potato&Password=letmein&AuthMethod=FormsAuthentication SAMLResponse=PD94bWwg aws_secret_access_key = abc123",
+			want:    []string{"Password=[REDACTED]&AuthMethod=FormsAuthentication", "SAMLResponse=[REDACTED]", "aws_secret_access_key = [REDACTED]"},
+			notWant: []string{"letmein", "PD94bWwg", "abc123"},
+		},
+		{
+			name:    "Short secrets are ignored...",
+			secrets: []string{"a"},
+			message: "a role was selected",
+			want:    []string{"a role was selected"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		var output bytes.Buffer
+		logger := New()
+		logger.SetOutput(&output)
+		for _, secret := range tt.secrets {
+			AddSecret(logger, secret)
+		}
+		logger.WithFields(tt.fields).Info(tt.message)
+		for _, want := range tt.want {
+			if !strings.Contains(output.String(), want) {
+				t.Errorf("Error running test -- got: %v want: %v", output.String(), want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(output.String(), notWant) {
+				t.Errorf("Error running test -- got: %v want: no %v", output.String(), notWant)
+			}
+		}
+	}
+}
+
+func Test_Add_Secret_Other_Loggers(t *testing.T) {
+	var output bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&output)
+	AddSecret(logger, "hunter22")
+	AddSecret(nil, "hunter22")
+	logger.Info("hunter22")
+	if !strings.Contains(output.String(), "hunter22") {
+		t.Errorf("Error running test -- got: %v want: %v", output.String(), "hunter22")
+	}
+}
+
+func Test_Remove_Secret(t *testing.T) {
+	var output bytes.Buffer
+	logger := New()
+	logger.SetOutput(&output)
+	AddSecret(logger, "oldsessiontoken")
+	AddSecret(logger, "newsessiontoken")
+	RemoveSecret(logger, "oldsessiontoken")
+	RemoveSecret(logger, "unknownsecret")
+	RemoveSecret(nil, "newsessiontoken")
+	logger.Info("oldsessiontoken newsessiontoken")
+	if got := output.String(); !strings.Contains(got, "oldsessiontoken [REDACTED]") {
+		t.Errorf("Error running test -- got: %v want: %v", got, "oldsessiontoken [REDACTED]")
+	}
+	if got := len(logger.Formatter.(*Redactor).secrets); got != 1 {
+		t.Errorf("Error running test -- got: %v secrets want: %v", got, 1)
+	}
+}
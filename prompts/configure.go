@@ -0,0 +1,54 @@
+package prompts
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/manifoldco/promptui"
+)
+
+// Prompts for a value, offering the current value as the default. The validate
+// function may be nil.
+func Text(label string, current string, validate func(string) error) (string, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		Default:   current,
+		AllowEdit: true,
+		Validate:  validate,
+	}
+
+	value, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("prompting for %s: %w", label, err)
+	}
+	return value, nil
+}
+
+// Prompts the user to choose one of the options, starting on the current option.
+func Choice(label string, options []string, current int) (int, error) {
+	prompt := promptui.Select{
+		Label: label,
+		Items: options,
+		Size:  len(options),
+	}
+
+	i, _, err := prompt.RunCursorAt(current, 0)
+	if err != nil {
+		return 0, fmt.Errorf("prompting for %s: %w", label, err)
+	}
+	return i, nil
+}
+
+// Asks a yes or no question, answering no is not an error.
+func Confirm(label string) (bool, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+	}
+
+	_, err := prompt.Run()
+	if errors.Is(err, promptui.ErrInterrupt) {
+		return false, fmt.Errorf("prompting for %s: %w", label, err)
+	}
+	return err == nil, nil
+}
@@ -3,21 +3,27 @@ package prompts
 import (
 	"errors"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/S7R4nG3/aws-adfs-login/types"
-	"github.com/S7R4nG3/aws-adfs-login/utils"
 	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
 )
 
 var (
 	minimumUsernameLength = 6
 	minimumPasswordLength = 6
 	minimumDomainLength   = 6
+	minimumListSize       = 4
+	defaultListSize       = 10
+	// Lines used by the select label, details block and search prompt
+	detailsHeight = 10
 )
 
 // Prompts the user for their login username and validates its minimum length
-func Username() string {
+func Username() (string, error) {
 	validate := func(input string) error {
 		if len(input) < minimumUsernameLength {
 			errStr := "username cannot be shorter than " + fmt.Sprint(minimumUsernameLength) + " characters"
@@ -32,13 +38,14 @@ func Username() string {
 	}
 
 	username, err := prompt.Run()
-	utils.Check(err, "Error prompting for username")
-
-	return username
+	if err != nil {
+		return "", fmt.Errorf("prompting for username: %w", err)
+	}
+	return username, nil
 }
 
 // Prompts the user for their login password and validates its minimum length
-func Password() string {
+func Password() (string, error) {
 	validate := func(input string) error {
 		if len(input) < minimumPasswordLength {
 			errStr := "password cannot be shorter than " + fmt.Sprint(minimumPasswordLength) + " characters"
@@ -54,13 +61,28 @@ func Password() string {
 	}
 
 	password, err := prompt.Run()
-	utils.Check(err, "Error prompting for password")
+	if err != nil {
+		return "", fmt.Errorf("prompting for password: %w", err)
+	}
+	return password, nil
+}
 
-	return password
+// Prompts for the passphrase of the encrypted keyring file
+func Passphrase() (string, error) {
+	prompt := promptui.Prompt{
+		Label: "Keyring passphrase: ",
+		Mask:  '*',
+	}
+
+	passphrase, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("prompting for keyring passphrase: %w", err)
+	}
+	return passphrase, nil
 }
 
 // Prompts the user for their selected domain and validates its minimum length
-func Domain() string {
+func Domain() (string, error) {
 	validate := func(input string) error {
 		if len(input) < minimumDomainLength {
 			errStr := "domain cannot be shorter than " + fmt.Sprint(minimumDomainLength) + " characters"
@@ -75,35 +97,299 @@ func Domain() string {
 	}
 
 	domain, err := prompt.Run()
-	utils.Check(err, "Error prompting for domain")
+	if err != nil {
+		return "", fmt.Errorf("prompting for domain: %w", err)
+	}
+	return domain, nil
+}
+
+// An entry in the grouped role selection, either a role or the header of the
+// account whose roles follow it. Headers can't be selected and are left out of
+// searches.
+type roleItem struct {
+	types.Role
+	Header string
+}
 
-	return domain
+// A position in the role selection. promptui only filters the items of a search,
+// keeping their order, so the positions are rearranged to point at the items in
+// the order of the search ranking and the best matches are listed first.
+type roleSlot struct {
+	*roleItem
+	index int
 }
 
 // Templates out the selection prompt for AWS IAM roles that the
-// now authenticated user has access to and can assume
-func RoleSelect(roles []types.Role) types.Role {
+// now authenticated user has access to and can assume. Roles are grouped
+// under a header for each account and the cursor starts on the preselected
+// role ARN when present.
+func RoleSelect(roles []types.Role, preselect string) (types.Role, error) {
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ .Name }}?",
-		Active:   "☁️ {{ .Name | cyan }}",
-		Inactive: "  {{ .Name }}",
-		Selected: "✅ {{ .Name | green }}",
-		Details:  `AWS Access Role -- {{ .Name }}`,
+		Active:   "{{ if .Header }}{{ .Header | bold }}{{ else }}  ☁️ {{ .RoleName | cyan }}{{ end }}",
+		Inactive: "{{ if .Header }}{{ .Header | bold }}{{ else }}    {{ .RoleName }}{{ end }}",
+		Selected: "{{ if not .Header }}✅ {{ .Label | green }}{{ end }}",
+		Details: `{{ if not .Header }}
+--------- AWS Access Role ----------
+{{ "Account ID:" | faint }}	{{ .AccountId }}
+{{ "Alias:" | faint }}	{{ .AccountAlias }}
+{{ "Role:" | faint }}	{{ .RoleName }}
+{{ "Provider:" | faint }}	{{ .PrincipalArn }}{{ end }}`,
 	}
 
+	items := groupRoles(roles)
+	slots := make([]*roleSlot, len(items))
+	for i := range slots {
+		slots[i] = &roleSlot{}
+	}
+	arrange := func(order []int) {
+		for i, index := range order {
+			slots[i].roleItem, slots[i].index = &items[index], index
+		}
+	}
+	arrange(identityOrder(len(items)))
+	matches := 0
 	searcher := func(input string, index int) bool {
-		role := roles[index]
-		return strings.Contains(role.Name, input)
+		// A search asks about every position in turn, so rank on the first
+		if index == 0 {
+			var order []int
+			order, matches = rankItems(input, items)
+			arrange(order)
+		}
+		return index < matches
 	}
 
 	prompt := promptui.Select{
 		Label:     "Access Role",
-		Items:     roles,
+		Items:     slots,
 		Templates: templates,
-		Size:      4,
+		Size:      listSize(len(items), terminalHeight()),
 		Searcher:  searcher,
 	}
 
-	i, _, _ := prompt.Run()
-	return roles[i]
+	// The first role follows the header of its account
+	cursor := 1
+	for i, item := range items {
+		if item.Header == "" && item.Name == preselect {
+			cursor = i
+		}
+	}
+	scroll := cursor - prompt.Size + 1
+	if scroll < 0 {
+		scroll = 0
+	}
+	for {
+		i, _, err := prompt.RunCursorAt(cursor, scroll)
+		if err != nil {
+			return types.Role{}, fmt.Errorf("selecting role: %w", err)
+		}
+		if slots[i].Header == "" {
+			return slots[i].Role, nil
+		}
+		// Choosing a header moves on to the first role of its account
+		cursor, scroll = slots[i].index+1, 0
+		arrange(identityOrder(len(items)))
+	}
+}
+
+// The item indexes in their original order.
+func identityOrder(size int) []int {
+	order := make([]int, size)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+// Orders the roles by account, sorted by alias or ID, keeping the order of the
+// roles within each account and inserting the header of each account before its roles.
+func groupRoles(roles []types.Role) []roleItem {
+	sorted := append([]types.Role{}, roles...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].AccountLabel() < sorted[j].AccountLabel()
+	})
+	var items []roleItem
+	for i, role := range sorted {
+		if i == 0 || sorted[i-1].AccountLabel() != role.AccountLabel() {
+			items = append(items, roleItem{Header: role.AccountLabel()})
+		}
+		items = append(items, roleItem{Role: role})
+	}
+	return items
+}
+
+// The height of the terminal in lines, zero when it isn't known.
+func terminalHeight() int {
+	_, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+	return height
+}
+
+// Sizes the list of items, one line each, to fit a terminal of the given height
+// along with the label and details, or to the default size when the height is zero.
+func listSize(items int, height int) int {
+	size := defaultListSize
+	if height > 0 {
+		size = height - detailsHeight
+	}
+	if size > items {
+		size = items
+	}
+	if size < minimumListSize {
+		size = minimumListSize
+	}
+	return size
+}
+
+// An entry in the multiple role selection, the first entry finishes the selection.
+type multiItem struct {
+	roleItem
+	Done     bool
+	Count    int
+	Selected bool
+}
+
+// A position in the multiple role selection, see roleSlot.
+type multiSlot struct {
+	*multiItem
+	index int
+}
+
+// Prompts the user to pick several roles, toggling a role each time it is chosen
+// until the selection is finished with the first entry.
+func RoleMultiSelect(roles []types.Role) ([]types.Role, error) {
+	templates := &promptui.SelectTemplates{
+		Label:    "{{ . }}?",
+		Active:   "{{ if .Done }}  ➡️ {{ print \"Done (\" .Count \" selected)\" | green }}{{ else if .Header }}{{ .Header | bold }}{{ else }}  ☁️ {{ if .Selected }}[x]{{ else }}[ ]{{ end }} {{ .RoleName | cyan }}{{ end }}",
+		Inactive: "{{ if .Done }}    {{ print \"Done (\" .Count \" selected)\" }}{{ else if .Header }}{{ .Header | bold }}{{ else }}    {{ if .Selected }}[x]{{ else }}[ ]{{ end }} {{ .RoleName }}{{ end }}",
+		Selected: "{{ if .Done }}✅ {{ print .Count \" roles selected\" | green }}{{ else }}{{ .Label }}{{ end }}",
+		Details: `{{ if not (or .Done .Header) }}
+--------- AWS Access Role ----------
+{{ "Account ID:" | faint }}	{{ .AccountId }}
+{{ "Alias:" | faint }}	{{ .AccountAlias }}
+{{ "Role:" | faint }}	{{ .RoleName }}
+{{ "Provider:" | faint }}	{{ .PrincipalArn }}{{ end }}`,
+	}
+
+	grouped := groupRoles(roles)
+	items := []multiItem{{Done: true}}
+	for _, item := range grouped {
+		items = append(items, multiItem{roleItem: item})
+	}
+	slots := make([]*multiSlot, len(items))
+	for i := range slots {
+		slots[i] = &multiSlot{}
+	}
+	arrange := func(order []int) {
+		for i, index := range order {
+			slots[i].multiItem, slots[i].index = &items[index], index
+		}
+	}
+	arrange(identityOrder(len(items)))
+	matches := 0
+	searcher := func(input string, index int) bool {
+		// A search asks about every position in turn, so rank on the first. The
+		// finishing entry isn't searchable and follows the matches
+		if index == 0 {
+			var ranked []int
+			ranked, matches = rankItems(input, grouped)
+			order := make([]int, 0, len(items))
+			for _, index := range ranked[:matches] {
+				order = append(order, index+1)
+			}
+			order = append(order, 0)
+			for _, index := range ranked[matches:] {
+				order = append(order, index+1)
+			}
+			arrange(order)
+		}
+		return index < matches
+	}
+
+	// The first role follows the header of its account
+	cursor := 2
+	for {
+		prompt := promptui.Select{
+			Label:        "Access Roles",
+			Items:        slots,
+			Templates:    templates,
+			Size:         listSize(len(items), terminalHeight()),
+			Searcher:     searcher,
+			HideSelected: true,
+		}
+		i, _, err := prompt.RunCursorAt(cursor, 0)
+		if err != nil {
+			return nil, fmt.Errorf("selecting roles: %w", err)
+		}
+		i = slots[i].index
+		arrange(identityOrder(len(items)))
+		if i == 0 {
+			break
+		}
+		if items[i].Header != "" {
+			cursor = i + 1
+			continue
+		}
+		items[i].Selected = !items[i].Selected
+		if items[i].Selected {
+			items[0].Count++
+		} else {
+			items[0].Count--
+		}
+		cursor = i
+	}
+
+	var selected []types.Role
+	for _, item := range items[1:] {
+		if item.Selected {
+			selected = append(selected, item.Role)
+		}
+	}
+	return selected, nil
+}
+
+// Prompts for the AWS profile name to write the role credentials to, suggesting a default.
+func Profile(role string, defaultProfile string) (string, error) {
+	validate := func(input string) error {
+		if strings.TrimSpace(input) == "" || strings.ContainsAny(input, "[] \t") {
+			return errors.New("profile must be a non-empty name without spaces or brackets")
+		}
+		return nil
+	}
+
+	prompt := promptui.Prompt{
+		Label:     "Profile for " + role,
+		Default:   defaultProfile,
+		AllowEdit: true,
+		Validate:  validate,
+	}
+
+	profile, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("prompting for profile: %w", err)
+	}
+	return profile, nil
+}
+
+// Prompts for the current code of the MFA device with the given serial number or ARN
+func MfaToken(serial string) (string, error) {
+	validate := func(input string) error {
+		if len(input) != 6 || strings.Trim(input, "0123456789") != "" {
+			return errors.New("MFA code must be 6 digits")
+		}
+		return nil
+	}
+
+	prompt := promptui.Prompt{
+		Label:    "MFA code for " + serial,
+		Validate: validate,
+	}
+
+	token, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("prompting for MFA code: %w", err)
+	}
+	return token, nil
 }
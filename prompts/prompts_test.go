@@ -0,0 +1,45 @@
+package prompts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Group_Roles(t *testing.T) {
+	got := groupRoles(testRoles)
+	want := []roleItem{
+		{Header: "987654321321"},
+		{Role: testRoles[4]},
+		{Header: "dev (111111111111)"},
+		{Role: testRoles[0]},
+		{Role: testRoles[1]},
+		{Header: "prod (123456789123)"},
+		{Role: testRoles[2]},
+		{Role: testRoles[3]},
+	}
+	t.Logf("Running test -- %s", "Validate roles are grouped under a header item for each account")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Error running test -- got: %v want: %v", got, want)
+	}
+}
+
+func Test_List_Size(t *testing.T) {
+	tests := []struct {
+		name   string
+		items  int
+		height int
+		want   int
+	}{
+		{name: "Validate the default size is used when the terminal height is unknown", items: 20, height: 0, want: defaultListSize},
+		{name: "Validate the list fills a tall terminal", items: 40, height: 30, want: 20},
+		{name: "Validate short lists aren't padded", items: 6, height: 30, want: 6},
+		{name: "Validate the list keeps a minimum size in short terminals", items: 20, height: 12, want: minimumListSize},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		if got := listSize(tt.items, tt.height); got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
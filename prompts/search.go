@@ -0,0 +1,135 @@
+package prompts
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+)
+
+const (
+	exactMatchScore       = 100
+	prefixMatchScore      = 80
+	wordBoundaryScore     = 60
+	substringMatchScore   = 40
+	subsequenceMatchScore = 20
+)
+
+// Scores how well a role matches a search query. The query is split on whitespace
+// and every term must match the account alias, account ID or role name, either
+// exactly, as a prefix, at a word boundary, as a substring, or as a fuzzy subsequence,
+// in decreasing order of score. The full ARN is searched last at half weight so
+// role paths remain searchable. A score of zero means the role doesn't match.
+func rankRole(query string, role types.Role) int {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return 1
+	}
+	fields := []string{role.AccountAlias, role.AccountId(), role.RoleName()}
+	total := 0
+	for _, term := range terms {
+		best := 0
+		for _, field := range fields {
+			if score := scoreField(term, field); score > best {
+				best = score
+			}
+		}
+		if score := scoreField(term, role.Name) / 2; score > best {
+			best = score
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total
+}
+
+func scoreField(term string, field string) int {
+	lower := strings.ToLower(field)
+	switch {
+	case field == "":
+		return 0
+	case lower == term:
+		return exactMatchScore
+	case strings.HasPrefix(lower, term):
+		return prefixMatchScore
+	}
+	// Match on runes, lowercasing can change the byte length of a rune but not the
+	// rune count, so the positions line up with the original field for the case check
+	runes, lowerRunes, termRunes := []rune(field), []rune(lower), []rune(term)
+	for index := 0; index+len(termRunes) <= len(lowerRunes); index++ {
+		if string(lowerRunes[index:index+len(termRunes)]) == term && isWordBoundary(runes, index) {
+			return wordBoundaryScore
+		}
+	}
+	if strings.Contains(lower, term) {
+		return substringMatchScore
+	}
+	return subsequenceScore(term, lower)
+}
+
+// Word boundaries follow separators such as / - _ : . or a lower to upper case
+// change, so "access" matches the second word of "AdministratorAccess".
+func isWordBoundary(runes []rune, index int) bool {
+	if index == 0 {
+		return true
+	}
+	prev, cur := runes[index-1], runes[index]
+	separator := !unicode.IsLetter(prev) && !unicode.IsDigit(prev)
+	return separator || (unicode.IsLower(prev) && unicode.IsUpper(cur))
+}
+
+// Scores terms whose characters appear in order within the field, penalizing the
+// gaps between them so tighter matches rank higher.
+func subsequenceScore(term string, field string) int {
+	gaps := 0
+	position := 0
+	started := false
+	for _, r := range term {
+		index := strings.IndexRune(field[position:], r)
+		if index < 0 {
+			return 0
+		}
+		if started {
+			gaps += index
+		}
+		started = true
+		position += index + len(string(r))
+	}
+	score := subsequenceMatchScore - gaps
+	if score < 1 {
+		score = 1
+	}
+	return score
+}
+
+// Orders the role selection items for the query, listing the matching roles from
+// the best to the worst match, ties keeping their order, followed by the remaining
+// items in their original order. Returns the item indexes and the number of matches.
+func rankItems(query string, items []roleItem) ([]int, int) {
+	type ranked struct {
+		index int
+		score int
+	}
+	var matches []ranked
+	var rest []int
+	for i, item := range items {
+		if item.Header == "" {
+			if score := rankRole(query, item.Role); score > 0 {
+				matches = append(matches, ranked{index: i, score: score})
+				continue
+			}
+		}
+		rest = append(rest, i)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	order := make([]int, 0, len(items))
+	for _, match := range matches {
+		order = append(order, match.index)
+	}
+	return append(order, rest...), len(matches)
+}
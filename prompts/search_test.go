@@ -0,0 +1,113 @@
+package prompts
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+)
+
+var testRoles = []types.Role{
+	{
+		Name:         "arn:aws:iam::111111111111:role/ReadOnly",
+		PrincipalArn: "arn:aws:iam::111111111111:saml-provider/ADFS",
+		AccountAlias: "dev",
+	},
+	{
+		Name:         "arn:aws:iam::111111111111:role/AdministratorAccess",
+		PrincipalArn: "arn:aws:iam::111111111111:saml-provider/ADFS",
+		AccountAlias: "dev",
+	},
+	{
+		Name:         "arn:aws:iam::123456789123:role/AdministratorAccess",
+		PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+		AccountAlias: "prod",
+	},
+	{
+		Name:         "arn:aws:iam::123456789123:role/ReadOnly",
+		PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+		AccountAlias: "prod",
+	},
+	{
+		Name:         "arn:aws:iam::987654321321:role/team/DeveloperAccess",
+		PrincipalArn: "arn:aws:iam::987654321321:saml-provider/ADFS",
+	},
+}
+
+func Test_Rank_Role(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		role  types.Role
+		want  int
+	}{
+		{name: "Validate an empty query matches everything", query: "", role: testRoles[0], want: 1},
+		{name: "Validate exact alias matches", query: "prod", role: testRoles[2], want: exactMatchScore},
+		{name: "Validate matching is case-insensitive", query: "READONLY", role: testRoles[0], want: exactMatchScore},
+		{name: "Validate prefix matches", query: "admin", role: testRoles[1], want: prefixMatchScore},
+		{name: "Validate camel case word boundaries", query: "access", role: testRoles[1], want: wordBoundaryScore},
+		{name: "Validate substring matches", query: "nistrator", role: testRoles[1], want: substringMatchScore},
+		{name: "Validate out of order characters do not match", query: "adm", role: testRoles[4], want: 0},
+		{name: "Validate fuzzy subsequence matches", query: "devacc", role: testRoles[4], want: subsequenceMatchScore - 6},
+		{name: "Validate looser subsequences score lower", query: "dvacc", role: testRoles[4], want: subsequenceMatchScore - 7},
+		{name: "Validate account ID prefix matches", query: "1234", role: testRoles[2], want: prefixMatchScore},
+		{name: "Validate role paths are searched through the ARN", query: "team", role: testRoles[4], want: wordBoundaryScore / 2},
+		{name: "Validate non-ASCII aliases match at word boundaries", query: "prod", role: types.Role{Name: "arn:aws:iam::111111111111:role/ReadOnly", AccountAlias: "İzmir-prod"}, want: wordBoundaryScore},
+		{name: "Validate non-ASCII aliases match as substrings", query: "zmir", role: types.Role{Name: "arn:aws:iam::111111111111:role/ReadOnly", AccountAlias: "İzmir-prod"}, want: substringMatchScore},
+		{name: "Validate every term must match", query: "prod admin", role: testRoles[3], want: 0},
+		{name: "Validate term scores are combined", query: "prod admin", role: testRoles[2], want: exactMatchScore + prefixMatchScore},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got := rankRole(tt.query, tt.role)
+		if got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Test_Rank_Items(t *testing.T) {
+	// The grouped items are the 987654321321 header, DeveloperAccess, the dev header,
+	// the dev roles, the prod header and the prod roles
+	items := groupRoles(testRoles)
+	tests := []struct {
+		name        string
+		query       string
+		want        []int
+		wantMatches int
+	}{
+		{
+			name:        "Validate alias and role terms find the single matching role",
+			query:       "prod admin",
+			want:        []int{6, 0, 1, 2, 3, 4, 5, 7},
+			wantMatches: 1,
+		},
+		{
+			name:        "Validate equal matches keep their order",
+			query:       "read",
+			want:        []int{3, 7, 0, 1, 2, 4, 5, 6},
+			wantMatches: 2,
+		},
+		{
+			name:        "Validate better matches are listed first",
+			query:       "dev",
+			want:        []int{3, 4, 1, 0, 2, 5, 6, 7},
+			wantMatches: 3,
+		},
+		{
+			name:        "Validate no matches keeps the original order",
+			query:       "staging",
+			want:        []int{0, 1, 2, 3, 4, 5, 6, 7},
+			wantMatches: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got, matches := rankItems(tt.query, items)
+		if !reflect.DeepEqual(got, tt.want) || matches != tt.wantMatches {
+			t.Errorf("Error running test -- got: %v (%d matches) want: %v (%d matches)", got, matches, tt.want, tt.wantMatches)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package saml
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+)
+
+const (
+	AwsSigninUrl = "https://signin.aws.amazon.com/saml"
+)
+
+// Matches the account headers of the AWS sign-in role selection page, which are
+// rendered as either "Account: alias (123456789012)" or "Account: 123456789012".
+var accountNamePattern = regexp.MustCompile(`^Account:\s*(?:(.+?)\s+\()?(\d{12})\)?$`)
+
+// Resolves account aliases by posting the SAML assertion to the AWS sign-in endpoint,
+// as a browser would, and parsing the account names from the role selection page.
+// The returned map is keyed by account ID.
+func (saml *Saml) AccountAliases(ctx context.Context) (map[string]string, error) {
+	log := saml.Logger
+	signinUrl := saml.SigninUrl
+	if signinUrl == "" {
+		signinUrl = AwsSigninUrl
+	}
+	log.Infof("Resolving account aliases from %s", signinUrl)
+	form := url.Values{
+		"SAMLResponse": {saml.Assertion},
+		"RelayState":   {""},
+	}
+	client, err := newSigninHttpClient(saml.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, signinUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("requesting the AWS sign-in page: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	page, err := client.Do(request)
+	if err != nil {
+		return nil, types.NewError(types.ErrNetwork, "requesting the AWS sign-in page", err)
+	}
+	defer page.Body.Close()
+	if page.StatusCode != http.StatusOK {
+		return nil, types.NewError(types.ErrNetwork, "requesting the AWS sign-in page: "+signinUrl+" returned "+page.Status, nil)
+	}
+	root, err := html.Parse(page.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the AWS sign-in page: %w", err)
+	}
+
+	aliases := map[string]string{}
+	for _, n := range scrape.FindAll(root, scrape.ByClass("saml-account-name")) {
+		match := accountNamePattern.FindStringSubmatch(strings.TrimSpace(scrape.Text(n)))
+		if match == nil || match[1] == "" {
+			continue
+		}
+		aliases[match[2]] = match[1]
+	}
+	log.Debugf("Resolved account aliases: %v", aliases)
+	return aliases, nil
+}
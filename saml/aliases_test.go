@@ -0,0 +1,74 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testAwsSignin = "../tests/aws-signin.html"
+)
+
+func Test_Account_Aliases(t *testing.T) {
+	var postedAssertion string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		postedAssertion = req.PostForm.Get("SAMLResponse")
+		testBody, _ := os.ReadFile(testAwsSignin)
+		rw.Write(testBody)
+	}))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+
+	tests := []struct {
+		name  string
+		input Saml
+		want  map[string]string
+	}{
+		{
+			name: "Validate account aliases are parsed from the sign-in page",
+			input: Saml{
+				Assertion: "someassertion",
+				SigninUrl: server.URL + "/saml",
+				Logger:    logrus.New(),
+			},
+			want: map[string]string{
+				"123456789123": "prod-account",
+			},
+		},
+		{
+			name: "Validate the CA bundle is trusted for the sign-in page",
+			input: Saml{
+				Assertion: "someotherassertion",
+				CABundle:  writePemCertificates(t, []*x509.Certificate{tlsServer.Certificate()}),
+				SigninUrl: tlsServer.URL + "/saml",
+				Logger:    logrus.New(),
+			},
+			want: map[string]string{
+				"123456789123": "prod-account",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got, err := tt.input.AccountAliases(context.Background())
+		if err != nil {
+			t.Errorf("Error running test -- %v", err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+		if postedAssertion != tt.input.Assertion {
+			t.Errorf("Error running test -- posted assertion: %v want: %v", postedAssertion, tt.input.Assertion)
+		}
+	}
+}
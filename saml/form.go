@@ -0,0 +1,134 @@
+package saml
+
+import (
+	"strings"
+
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Locates the login form on the portal page. When a form selector is provided the
+// first form whose id, name or action matches it is used, otherwise the first form
+// containing a password input is preferred, falling back to the first form on the page.
+func findLoginForm(root *html.Node, selector string) (*html.Node, bool) {
+	forms := scrape.FindAll(root, scrape.ByTag(atom.Form))
+	if selector != "" {
+		for _, form := range forms {
+			if formMatchesSelector(form, selector) {
+				return form, true
+			}
+		}
+		return nil, false
+	}
+	for _, form := range forms {
+		if _, ok := scrape.Find(form, isPasswordInput); ok {
+			return form, true
+		}
+	}
+	if len(forms) > 0 {
+		return forms[0], true
+	}
+	return nil, false
+}
+
+// Checks a form against a simple selector - `#id` matches the form id while a bare
+// value matches the id, name or action attributes.
+func formMatchesSelector(form *html.Node, selector string) bool {
+	if strings.HasPrefix(selector, "#") {
+		return scrape.Attr(form, "id") == strings.TrimPrefix(selector, "#")
+	}
+	return scrape.Attr(form, "id") == selector ||
+		scrape.Attr(form, "name") == selector ||
+		scrape.Attr(form, "action") == selector
+}
+
+// Identifies the names of the username and password inputs within the login form.
+// Explicit overrides always win, otherwise the password field is the first input with
+// a password type (or current-password autocomplete hint) and the username field is the
+// first input hinted as a username/email, falling back to the visible text input
+// immediately preceding the password field.
+func findCredentialFields(inputs []*html.Node, usernameOverride string, passwordOverride string) (string, string) {
+	passwordField := passwordOverride
+	if passwordField == "" {
+		for _, n := range inputs {
+			if isPasswordInput(n) {
+				passwordField = scrape.Attr(n, "name")
+				break
+			}
+		}
+	}
+
+	usernameField := usernameOverride
+	if usernameField == "" {
+		for _, n := range inputs {
+			autocomplete := strings.ToLower(scrape.Attr(n, "autocomplete"))
+			if autocomplete == "username" || autocomplete == "email" || inputType(n) == "email" {
+				usernameField = scrape.Attr(n, "name")
+				break
+			}
+		}
+	}
+	if usernameField == "" {
+		for _, n := range inputs {
+			name := scrape.Attr(n, "name")
+			if name == passwordField {
+				break
+			}
+			if inputType(n) == "text" && name != "" {
+				usernameField = name
+			}
+		}
+	}
+	return usernameField, passwordField
+}
+
+func isPasswordInput(n *html.Node) bool {
+	if n.DataAtom != atom.Input {
+		return false
+	}
+	return inputType(n) == "password" || strings.ToLower(scrape.Attr(n, "autocomplete")) == "current-password"
+}
+
+// Returns the lowercased input type, defaulting to text as browsers do.
+func inputType(n *html.Node) string {
+	t := strings.ToLower(scrape.Attr(n, "type"))
+	if t == "" {
+		return "text"
+	}
+	return t
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// The ways the username can be submitted in the login form.
+const (
+	// DOMAIN\username, the ADFS default
+	UsernameFormatDomain = "domain"
+	// username@domain, the user principal name
+	UsernameFormatUpn = "upn"
+	// The username as entered
+	UsernameFormatPlain = "plain"
+)
+
+// The supported username formats.
+var UsernameFormats = []string{UsernameFormatDomain, UsernameFormatUpn, UsernameFormatPlain}
+
+// Formats the username submitted in the login form, defaulting to DOMAIN\username.
+func loginName(format string, username string, domain string) string {
+	switch {
+	case format == UsernameFormatPlain || domain == "":
+		return username
+	case format == UsernameFormatUpn:
+		return username + "@" + domain
+	default:
+		return domain + `\` + username
+	}
+}
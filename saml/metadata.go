@@ -0,0 +1,238 @@
+package saml
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	federationMetadataPath = "/FederationMetadata/2007-06/FederationMetadata.xml"
+	idpInitiatedSignOnPage = "IdpInitiatedSignOn.aspx?loginToRp=" + awsAudience
+	DefaultMetadataTTL     = 24 * time.Hour
+)
+
+// The parsed contents of an ADFS FederationMetadata.xml document.
+type FederationMetadata struct {
+	Location             string
+	EntityID             string
+	DisplayName          string
+	SigningCertificates  []*x509.Certificate
+	SingleSignOnServices []Endpoint
+	SingleLogoutServices []Endpoint
+	FetchedAt            time.Time
+}
+
+// A SAML protocol endpoint advertised by the IdP.
+type Endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+// Downloads federation metadata and caches it on disk so repeated logins don't
+// need a round trip to the IdP until the TTL expires.
+type MetadataCache struct {
+	Dir      string
+	TTL      time.Duration
+	CABundle string
+	Refresh  bool
+	// Fall back to an expired copy when the IdP can't be reached, only safe when
+	// the metadata is displayed rather than trusted to verify assertions
+	AllowStale bool
+	Logger     *logrus.Logger
+}
+
+type xmlEntityDescriptor struct {
+	EntityID         string              `xml:"entityID,attr"`
+	RoleDescriptors  []xmlRoleDescriptor `xml:"RoleDescriptor"`
+	IDPSSODescriptor struct {
+		KeyDescriptors       []keyDescriptor `xml:"KeyDescriptor"`
+		SingleSignOnServices []Endpoint      `xml:"SingleSignOnService"`
+		SingleLogoutServices []Endpoint      `xml:"SingleLogoutService"`
+	} `xml:"IDPSSODescriptor"`
+	OrganizationDisplayName string `xml:"Organization>OrganizationDisplayName"`
+}
+
+type xmlRoleDescriptor struct {
+	ServiceDisplayName string `xml:"ServiceDisplayName,attr"`
+}
+
+type keyDescriptor struct {
+	Use          string   `xml:"use,attr"`
+	Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+}
+
+// Derives the well-known ADFS federation metadata URL from the IDP entry URL.
+func MetadataUrl(idpEntryUrl string) (string, error) {
+	u, err := url.Parse(idpEntryUrl)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid IDP entry URL %q", idpEntryUrl)
+	}
+	return u.Scheme + "://" + u.Host + federationMetadataPath, nil
+}
+
+// Returns a default cache rooted in the user's cache directory.
+func NewMetadataCache(caBundle string, logger *logrus.Logger) *MetadataCache {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return &MetadataCache{
+		Dir:      filepath.Join(dir, "aws-adfs-login", "metadata"),
+		TTL:      DefaultMetadataTTL,
+		CABundle: caBundle,
+		Logger:   logger,
+	}
+}
+
+// Loads federation metadata from a local path or https URL. URLs are served from the
+// disk cache while it is younger than the TTL. Plain http URLs are refused, as the
+// metadata carries the certificates trusted to sign the assertion.
+func (cache *MetadataCache) Load(ctx context.Context, location string) (*FederationMetadata, error) {
+	if strings.HasPrefix(location, "http://") {
+		return nil, fmt.Errorf("loading federation metadata from %s: plain http is refused, use an https URL or a local file", location)
+	}
+	if !strings.HasPrefix(location, "https://") {
+		content, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("reading federation metadata: %w", err)
+		}
+		info, _ := os.Stat(location)
+		return parseFederationMetadata(location, content, info.ModTime())
+	}
+
+	log := cache.Logger
+	cacheFile := cache.path(location)
+	info, statErr := os.Stat(cacheFile)
+	if statErr == nil && !cache.Refresh && now().Sub(info.ModTime()) < cache.TTL {
+		log.Debugf("Using cached federation metadata from %s", cacheFile)
+		content, err := ioutil.ReadFile(cacheFile)
+		if err == nil {
+			return parseFederationMetadata(location, content, info.ModTime())
+		}
+	}
+
+	content, err := cache.fetch(ctx, location)
+	if err != nil {
+		if statErr == nil && cache.AllowStale {
+			log.Warnf("Unable to refresh federation metadata, using cached copy -- %v", err)
+			content, readErr := ioutil.ReadFile(cacheFile)
+			if readErr == nil {
+				return parseFederationMetadata(location, content, info.ModTime())
+			}
+		}
+		return nil, err
+	}
+	metadata, err := parseFederationMetadata(location, content, now())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cache.Dir, 0700); err == nil {
+		if err := ioutil.WriteFile(cacheFile, content, 0600); err != nil {
+			log.Warnf("Unable to cache federation metadata -- %v", err)
+		}
+	}
+	return metadata, nil
+}
+
+func (cache *MetadataCache) fetch(ctx context.Context, location string) ([]byte, error) {
+	cache.Logger.Infof("Fetching federation metadata from %s", location)
+	client, err := newHttpClient(cache.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("requesting federation metadata: %w", err)
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, types.NewError(types.ErrNetwork, "requesting federation metadata", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, types.NewError(types.ErrNetwork, "requesting federation metadata: "+location+" returned "+resp.Status, nil)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading federation metadata: %w", err)
+	}
+	return content, nil
+}
+
+func (cache *MetadataCache) path(location string) string {
+	sum := sha256.Sum256([]byte(location))
+	return filepath.Join(cache.Dir, hex.EncodeToString(sum[:8])+".xml")
+}
+
+func parseFederationMetadata(location string, content []byte, fetchedAt time.Time) (*FederationMetadata, error) {
+	var entity xmlEntityDescriptor
+	if err := xml.Unmarshal(content, &entity); err != nil {
+		return nil, fmt.Errorf("parsing federation metadata: %w", err)
+	}
+	metadata := &FederationMetadata{
+		Location:             location,
+		EntityID:             entity.EntityID,
+		DisplayName:          entity.OrganizationDisplayName,
+		SingleSignOnServices: entity.IDPSSODescriptor.SingleSignOnServices,
+		SingleLogoutServices: entity.IDPSSODescriptor.SingleLogoutServices,
+		FetchedAt:            fetchedAt,
+	}
+	for _, role := range entity.RoleDescriptors {
+		if role.ServiceDisplayName != "" {
+			metadata.DisplayName = role.ServiceDisplayName
+			break
+		}
+	}
+	for _, key := range entity.IDPSSODescriptor.KeyDescriptors {
+		if key.Use != "" && key.Use != "signing" {
+			continue
+		}
+		for _, encoded := range key.Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in federation metadata: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in federation metadata: %w", err)
+			}
+			metadata.SigningCertificates = append(metadata.SigningCertificates, cert)
+		}
+	}
+	return metadata, nil
+}
+
+// The IdP-initiated sign-on URL for AWS, built from the advertised SSO endpoint.
+func (metadata *FederationMetadata) SignOnUrl() string {
+	for _, endpoint := range metadata.SingleSignOnServices {
+		if endpoint.Location != "" {
+			return strings.TrimSuffix(endpoint.Location, "/") + "/" + idpInitiatedSignOnPage
+		}
+	}
+	return ""
+}
+
+// A SHA-256 fingerprint in the colon separated form shown by the ADFS console.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
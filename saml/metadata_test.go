@@ -0,0 +1,149 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testFederationMetadata = "../tests/federation-metadata.xml"
+)
+
+func Test_Federation_Metadata(t *testing.T) {
+	cache := MetadataCache{
+		Dir:    t.TempDir(),
+		TTL:    DefaultMetadataTTL,
+		Logger: logrus.New(),
+	}
+	got, err := cache.Load(context.Background(), testFederationMetadata)
+	if err != nil {
+		t.Fatalf("Error loading federation metadata -- %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "Validate the display name", got: got.DisplayName, want: "Example Corp ADFS"},
+		{name: "Validate the entity ID", got: got.EntityID, want: "http://adfs.example/adfs/services/trust"},
+		{name: "Validate only signing certificates are kept", got: len(got.SigningCertificates), want: 1},
+		{name: "Validate the signing certificate subject", got: got.SigningCertificates[0].Subject.CommonName, want: "ADFS Signing - adfs.example"},
+		{name: "Validate the SSO endpoints", got: len(got.SingleSignOnServices), want: 2},
+		{name: "Validate the logout endpoints", got: len(got.SingleLogoutServices), want: 2},
+		{name: "Validate the sign-on URL", got: got.SignOnUrl(), want: "https://adfs.example/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices"},
+		{name: "Validate the certificate fingerprint", got: Fingerprint(got.SigningCertificates[0]), want: "EB:AF:6A:41:03:E5:44:A6:92:37:47:26:48:EA:55:70:6F:99:57:74:E4:99:F6:E3:8E:AC:CB:B4:EE:B0:BF:84"},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		if tt.got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", tt.got, tt.want)
+		}
+	}
+}
+
+func Test_Metadata_Url(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Validate the metadata URL is derived from a bare host",
+			input: "https://adfs.example",
+			want:  "https://adfs.example/FederationMetadata/2007-06/FederationMetadata.xml",
+		},
+		{
+			name:  "Validate the metadata URL is derived from the sign-on page",
+			input: "https://adfs.example/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices",
+			want:  "https://adfs.example/FederationMetadata/2007-06/FederationMetadata.xml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got, err := MetadataUrl(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("Error running test -- got: %v (%v) want: %v", got, err, tt.want)
+		}
+	}
+}
+
+func Test_Metadata_Cache(t *testing.T) {
+	fixedNow := time.Now()
+	now = func() time.Time { return fixedNow }
+	defer func() { now = time.Now }()
+
+	hits := 0
+	available := true
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !available {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		hits++
+		body, _ := os.ReadFile(testFederationMetadata)
+		rw.Write(body)
+	}))
+	defer server.Close()
+
+	cache := MetadataCache{
+		Dir:      t.TempDir(),
+		TTL:      time.Hour,
+		CABundle: writePemCertificates(t, []*x509.Certificate{server.Certificate()}),
+		Logger:   logrus.New(),
+	}
+	location := server.URL + federationMetadataPath
+
+	tests := []struct {
+		name       string
+		location   string
+		advance    time.Duration
+		available  bool
+		refresh    bool
+		allowStale bool
+		wantHits   int
+		wantErr    bool
+	}{
+		{name: "Validate the first load fetches the metadata", available: true, wantHits: 1},
+		{name: "Validate loads within the TTL are served from cache", advance: 30 * time.Minute, available: true, wantHits: 1},
+		{name: "Validate a refresh bypasses the cache", available: true, refresh: true, wantHits: 2},
+		{name: "Validate loads past the TTL fetch again", advance: 2 * time.Hour, available: true, wantHits: 3},
+		{name: "Validate a stale cache isn't trusted when the IdP is unavailable", advance: 2 * time.Hour, available: false, wantHits: 3, wantErr: true},
+		{name: "Validate a stale cache is used when allowed", available: false, allowStale: true, wantHits: 3},
+		{name: "Validate plain http metadata is refused", location: "http://adfs.example" + federationMetadataPath, available: true, wantHits: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		fixedNow = fixedNow.Add(tt.advance)
+		available = tt.available
+		cache.Refresh = tt.refresh
+		cache.AllowStale = tt.allowStale
+		if tt.location == "" {
+			tt.location = location
+		}
+		got, err := cache.Load(context.Background(), tt.location)
+		if tt.wantErr {
+			if err == nil || hits != tt.wantHits {
+				t.Errorf("Error running test -- got: %v (hits: %d) want an error (hits: %d)", err, hits, tt.wantHits)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error running test -- %v", err)
+			continue
+		}
+		if hits != tt.wantHits || got.DisplayName != "Example Corp ADFS" {
+			t.Errorf("Error running test -- got hits: %d want: %d", hits, tt.wantHits)
+		}
+	}
+}
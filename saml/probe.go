@@ -0,0 +1,69 @@
+package saml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The login form found on an IdP sign-in page.
+type LoginProbe struct {
+	Url           string
+	ActionUrl     string
+	UsernameField string
+	PasswordField string
+	Adfs          bool
+}
+
+// Fetches the IdP entry URL and checks that it serves a sign-in page with a login
+// form, reporting the form fields and whether the page appears to be served by ADFS.
+func Probe(ctx context.Context, idpEntryUrl string, caBundle string) (LoginProbe, error) {
+	probe := LoginProbe{Url: idpEntryUrl}
+	if !strings.HasPrefix(idpEntryUrl, "https://") && !strings.HasPrefix(idpEntryUrl, "http://") {
+		return probe, fmt.Errorf("%s is not an http(s) URL", idpEntryUrl)
+	}
+	client, err := newHttpClient(caBundle)
+	if err != nil {
+		return probe, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, idpEntryUrl, nil)
+	if err != nil {
+		return probe, err
+	}
+	page, err := client.Do(request)
+	if err != nil {
+		return probe, types.NewError(types.ErrNetwork, "requesting "+idpEntryUrl, err)
+	}
+	defer page.Body.Close()
+	if page.StatusCode >= 400 {
+		return probe, fmt.Errorf("%s returned %s", idpEntryUrl, page.Status)
+	}
+	probe.Url = page.Request.URL.String()
+	root, err := html.Parse(page.Body)
+	if err != nil {
+		return probe, err
+	}
+
+	form, ok := findLoginForm(root, "")
+	if !ok {
+		return probe, errors.New("no login form found on the page")
+	}
+	inputs := scrape.FindAll(form, func(hn *html.Node) bool {
+		return hn.DataAtom == atom.Input
+	})
+	probe.UsernameField, probe.PasswordField = findCredentialFields(inputs, "", "")
+	if probe.UsernameField == "" || probe.PasswordField == "" {
+		return probe, errors.New("the login form has no username and password inputs")
+	}
+	probe.ActionUrl = scrape.Attr(form, "action")
+	probe.Adfs = strings.Contains(strings.ToLower(page.Request.URL.Path), "/adfs/") ||
+		strings.Contains(strings.ToLower(probe.ActionUrl), "/adfs/")
+	return probe, nil
+}
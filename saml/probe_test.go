@@ -0,0 +1,83 @@
+package saml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test_Probe(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/adfs/ls/idpinitiatedsignon.aspx", func(rw http.ResponseWriter, req *http.Request) {
+		testBody, _ := os.ReadFile(testLoginPage)
+		rw.Write(testBody)
+	})
+	mux.HandleFunc("/login", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`<html><body><form action="/session"><input name="user" type="email"/><input name="pass" type="password"/></form></body></html>`))
+	})
+	mux.HandleFunc("/redirect", func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, "/adfs/ls/idpinitiatedsignon.aspx", http.StatusFound)
+	})
+	mux.HandleFunc("/home", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`<html><body><h1>Welcome</h1></body></html>`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantUrl  string
+		wantAdfs bool
+		wantErr  bool
+	}{
+		{
+			name:     "Validate an ADFS sign-in page is recognized",
+			url:      server.URL + "/adfs/ls/idpinitiatedsignon.aspx",
+			wantUrl:  server.URL + "/adfs/ls/idpinitiatedsignon.aspx",
+			wantAdfs: true,
+		},
+		{
+			name:     "Validate redirects are followed to the sign-in page",
+			url:      server.URL + "/redirect",
+			wantUrl:  server.URL + "/adfs/ls/idpinitiatedsignon.aspx",
+			wantAdfs: true,
+		},
+		{
+			name:    "Validate another sign-in page is found but not recognized as ADFS",
+			url:     server.URL + "/login",
+			wantUrl: server.URL + "/login",
+		},
+		{
+			name:    "Validate a page without a login form is an error",
+			url:     server.URL + "/home",
+			wantErr: true,
+		},
+		{
+			name:    "Validate a missing page is an error",
+			url:     server.URL + "/missing",
+			wantErr: true,
+		},
+		{
+			name:    "Validate a URL without a scheme is an error",
+			url:     "adfs.example/adfs/ls",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got, err := Probe(context.Background(), tt.url, "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got.Url != tt.wantUrl || got.Adfs != tt.wantAdfs || got.UsernameField == "" || got.PasswordField == "" {
+			t.Errorf("Error running test -- got: %+v want: %v adfs %v", got, tt.wantUrl, tt.wantAdfs)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package saml
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+)
+
+// Parses a single Role attribute value into its IAM role and SAML provider ARNs.
+// IdPs emit the pair in either order, so each half is identified by its resource
+// type rather than its position.
+func parseRoleAttribute(value string) (types.Role, error) {
+	var role types.Role
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return role, fmt.Errorf("expected a role and provider ARN pair, got %q", value)
+	}
+	for _, part := range parts {
+		arn := strings.TrimSpace(part)
+		switch {
+		case isIamArn(arn, "role/") && role.Name == "":
+			role.Name = arn
+		case isIamArn(arn, "saml-provider/") && role.PrincipalArn == "":
+			role.PrincipalArn = arn
+		default:
+			return types.Role{}, fmt.Errorf("unrecognized ARN %q in role attribute %q", arn, value)
+		}
+	}
+	return role, nil
+}
+
+// Checks the value looks like arn:<partition>:iam::<account>:<resource type><name>.
+func isIamArn(arn string, resourceType string) bool {
+	fields := strings.SplitN(arn, ":", 6)
+	if len(fields) != 6 || fields[0] != "arn" || fields[1] == "" || fields[2] != "iam" || fields[3] != "" {
+		return false
+	}
+	if len(fields[4]) != 12 || strings.Trim(fields[4], "0123456789") != "" {
+		return false
+	}
+	return strings.HasPrefix(fields[5], resourceType) && len(fields[5]) > len(resourceType) && !strings.ContainsAny(fields[5], " \t\r\n")
+}
+
+func containsRole(roles []types.Role, role types.Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
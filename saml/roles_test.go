@@ -0,0 +1,106 @@
+package saml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/S7R4nG3/aws-adfs-login/types"
+)
+
+func Test_Role_Attribute_Parsing(t *testing.T) {
+	want := types.Role{
+		Name:         "arn:aws:iam::123456789123:role/AdministratorAccess",
+		PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+	}
+	tests := []struct {
+		name    string
+		input   string
+		want    types.Role
+		wantErr bool
+	}{
+		{
+			name:  "Validate provider,role order",
+			input: "arn:aws:iam::123456789123:saml-provider/ADFS,arn:aws:iam::123456789123:role/AdministratorAccess",
+			want:  want,
+		},
+		{
+			name:  "Validate role,provider order",
+			input: "arn:aws:iam::123456789123:role/AdministratorAccess,arn:aws:iam::123456789123:saml-provider/ADFS",
+			want:  want,
+		},
+		{
+			name:  "Validate surrounding whitespace is trimmed",
+			input: "\n  arn:aws:iam::123456789123:saml-provider/ADFS , arn:aws:iam::123456789123:role/AdministratorAccess\t",
+			want:  want,
+		},
+		{
+			name:  "Validate role paths and other partitions",
+			input: "arn:aws-us-gov:iam::123456789123:saml-provider/ADFS,arn:aws-us-gov:iam::123456789123:role/team/Developer",
+			want: types.Role{
+				Name:         "arn:aws-us-gov:iam::123456789123:role/team/Developer",
+				PrincipalArn: "arn:aws-us-gov:iam::123456789123:saml-provider/ADFS",
+			},
+		},
+		{
+			name:    "Validate a value without a comma is rejected",
+			input:   "arn:aws:iam::123456789123:role/AdministratorAccess",
+			wantErr: true,
+		},
+		{
+			name:    "Validate two roles are rejected",
+			input:   "arn:aws:iam::123456789123:role/One,arn:aws:iam::123456789123:role/Two",
+			wantErr: true,
+		},
+		{
+			name:    "Validate extra values are rejected",
+			input:   "arn:aws:iam::123456789123:saml-provider/ADFS,arn:aws:iam::123456789123:role/AdministratorAccess,",
+			wantErr: true,
+		},
+		{
+			name:    "Validate non IAM ARNs are rejected",
+			input:   "arn:aws:sts::123456789123:saml-provider/ADFS,arn:aws:iam::123456789123:role/AdministratorAccess",
+			wantErr: true,
+		},
+		{
+			name:    "Validate malformed account IDs are rejected",
+			input:   "arn:aws:iam::1234:saml-provider/ADFS,arn:aws:iam::1234:role/AdministratorAccess",
+			wantErr: true,
+		},
+		{
+			name:    "Validate empty values are rejected",
+			input:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		got, err := parseRoleAttribute(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Error running test -- got error: %v want error: %v", err, tt.wantErr)
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
+		}
+	}
+}
+
+func Fuzz_Role_Attribute_Parsing(f *testing.F) {
+	f.Add("arn:aws:iam::123456789123:saml-provider/ADFS,arn:aws:iam::123456789123:role/AdministratorAccess")
+	f.Add("arn:aws:iam::123456789123:role/AdministratorAccess, arn:aws:iam::123456789123:saml-provider/ADFS")
+	f.Add("arn:aws:iam::123456789123:role/AdministratorAccess")
+	f.Add(",")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, input string) {
+		got, err := parseRoleAttribute(input)
+		if err != nil {
+			return
+		}
+		if !strings.Contains(got.Name, ":role/") || !strings.Contains(got.PrincipalArn, ":saml-provider/") {
+			t.Errorf("Error running fuzz -- got misidentified role: %v from: %q", got, input)
+		}
+		if strings.TrimSpace(got.Name) != got.Name || strings.TrimSpace(got.PrincipalArn) != got.PrincipalArn {
+			t.Errorf("Error running fuzz -- got untrimmed role: %v from: %q", got, input)
+		}
+	})
+}
@@ -1,31 +1,55 @@
 package saml
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/xml"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/S7R4nG3/aws-adfs-login/logging"
 	"github.com/S7R4nG3/aws-adfs-login/types"
-	"github.com/S7R4nG3/aws-adfs-login/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/yhat/scrape"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
+const (
+	awsAttributePrefix         = "https://aws.amazon.com/SAML/Attributes/"
+	roleAttribute              = awsAttributePrefix + "Role"
+	roleSessionNameAttribute   = awsAttributePrefix + "RoleSessionName"
+	sessionDurationAttribute   = awsAttributePrefix + "SessionDuration"
+	sourceIdentityAttribute    = awsAttributePrefix + "SourceIdentity"
+	transitiveTagKeysAttribute = awsAttributePrefix + "TransitiveTagKeys"
+	principalTagAttribute      = awsAttributePrefix + "PrincipalTag:"
+)
+
 type Saml struct {
-	IdpEntryUrl     string
-	CABundle        string
-	LoginPage       LoginPage
-	Assertion       string
-	DecodedSaml     []byte
-	SamlXMLResponse SamlXMLResponse
-	Logger          *logrus.Logger
+	IdpEntryUrl       string
+	CABundle          string
+	UsernameFormat    string
+	UsernameField     string
+	PasswordField     string
+	FormSelector      string
+	ValidateAssertion bool
+	IdpCertificate    string
+	IdpMetadata       string
+	SigninUrl         string
+	User              types.User
+	LoginPage         LoginPage
+	Assertion         string
+	DecodedSaml       []byte
+	SamlXMLResponse   SamlXMLResponse
+	Attributes        AwsAttributes
+	Roles             []types.Role
+	Logger            *logrus.Logger
 }
 
 type LoginPage struct {
@@ -33,9 +57,19 @@ type LoginPage struct {
 	FormData  url.Values
 }
 
+// The AWS specific attributes carried by the SAML assertion, other than the roles.
+type AwsAttributes struct {
+	RoleSessionName   string
+	SessionDuration   int
+	SourceIdentity    string
+	PrincipalTags     map[string]string
+	TransitiveTagKeys []string
+}
+
 type SamlXMLResponse struct {
-	XMLName xml.Name       `xml:"Response"`
-	Attrs   []XmlAttribute `xml:"Assertion>AttributeStatement>Attribute"`
+	XMLName    xml.Name       `xml:"Response"`
+	Attrs      []XmlAttribute `xml:"Assertion>AttributeStatement>Attribute"`
+	Conditions XmlConditions  `xml:"Assertion>Conditions"`
 }
 
 type XmlAttribute struct {
@@ -46,120 +80,244 @@ type XmlAttribute struct {
 // Primary entrypoint to begin SAML verification - this first accesses the login portal
 // via the provided IDP Entry URL and identifies the Username, Password, and Submit fields.
 // Next, it POSTs the contents of the user's login information to retrieve a SAML response.
-// The response is then decoded and parsed to identify the AWS IAM roles that the user has access
-// to assume, and these roles are written to a global types variable to be accessible by
-// the end user prompts for selection of a specific role.
-func (saml *Saml) Verify() {
+// The response is then decoded, optionally validated, and parsed to identify the AWS IAM roles that the user has access
+// to assume, and these roles are written to the Roles field to be accessible by
+// the end user prompts for selection of a specific role. Failures are returned as
+// types.LoginError values of the ErrNetwork, ErrAuthFailed or ErrNoRoles kinds where
+// they apply.
+func (saml *Saml) Verify(ctx context.Context) error {
 	log := saml.Logger
 	log.Info("Begin Saml request...")
-	saml.portalLogin()
-	log.Infof("Login portal parsed: %v", saml.LoginPage)
-	saml.assertion()
-	saml.DecodedSaml, _ = base64.StdEncoding.DecodeString(saml.Assertion)
-	saml.parseSamlRoles()
+	if err := saml.portalLogin(ctx); err != nil {
+		return err
+	}
+	log.Infof("Login portal parsed, submitting the login form to %s", saml.LoginPage.ActionUrl)
+	if err := saml.assertion(ctx); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(saml.Assertion)
+	if err != nil {
+		return types.NewError(types.ErrAuthFailed, "decoding SAML assertion", err)
+	}
+	saml.DecodedSaml = decoded
+	if saml.ValidateAssertion {
+		verified, err := saml.validateAssertion(ctx)
+		if err != nil {
+			return types.NewError(types.ErrAuthFailed, "validating SAML assertion", err)
+		}
+		// Read the roles and attributes from the signed content only
+		saml.DecodedSaml = verified
+	}
+	if err := saml.parseSamlAttributes(); err != nil {
+		return err
+	}
 	log.Info("Saml verification complete!")
+	return nil
 }
 
-// Orchestrations the login portal authentication with the provided username and
-// password. the request URL and response are written back to the paren SAML struct
-func (saml *Saml) portalLogin() {
+// Orchestrations the login portal authentication with the username and password
+// of the User. the request URL and response are written back to the paren SAML struct
+func (saml *Saml) portalLogin(ctx context.Context) error {
 	log := saml.Logger
 	log.Info("Begin Portal login...")
-	client := saml.newHttpClient()
-	page, err := client.Get(saml.IdpEntryUrl)
-	utils.Check(err, "Error in GET to login portal URL")
+	client, err := saml.newHttpClient()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, saml.IdpEntryUrl, nil)
+	if err != nil {
+		return fmt.Errorf("requesting the login portal: %w", err)
+	}
+	page, err := client.Do(request)
+	if err != nil {
+		return types.NewError(types.ErrNetwork, "requesting the login portal", err)
+	}
 	defer page.Body.Close()
 	root, err := html.Parse(page.Body)
-	utils.Check(err, "Error parsing Login Portal HTML")
+	if err != nil {
+		return fmt.Errorf("parsing the login portal HTML: %w", err)
+	}
 
-	inputs := scrape.FindAll(root, func(hn *html.Node) bool {
+	form, ok := findLoginForm(root, saml.FormSelector)
+	if !ok {
+		return types.NewError(types.ErrAuthFailed, fmt.Sprintf("locating the login portal form: no login form matching %q found", saml.FormSelector), nil)
+	}
+	inputs := scrape.FindAll(form, func(hn *html.Node) bool {
 		return hn.DataAtom == atom.Input
 	})
-	form, _ := scrape.Find(root, func(hn *html.Node) bool {
-		return hn.DataAtom == atom.Form
-	})
+	usernameField, passwordField := findCredentialFields(inputs, saml.UsernameField, saml.PasswordField)
+	if usernameField == "" || passwordField == "" {
+		return types.NewError(types.ErrAuthFailed, "locating the login portal credential fields: no username and password inputs found", nil)
+	}
+	log.Debugf("Login form fields identified -- Username: %s :: Password: %s", usernameField, passwordField)
 
 	formData := url.Values{}
-	userWithDomain := types.LoginUser.Domain + `\` + types.LoginUser.Username
+	userWithDomain := loginName(saml.UsernameFormat, saml.User.Username, saml.User.Domain)
 
 	for _, n := range inputs {
 		name := scrape.Attr(n, "name")
 		value := scrape.Attr(n, "value")
 		switch {
-		case strings.Contains(name, "Password"):
-			formData.Set(name, types.LoginUser.Password)
-		case strings.Contains(name, "Username"):
+		case name == "":
+			continue
+		case name == passwordField:
+			formData.Set(name, saml.User.Password)
+		case name == usernameField:
 			formData.Set(name, userWithDomain)
+		case (inputType(n) == "checkbox" || inputType(n) == "radio") && !hasAttr(n, "checked"):
+			continue
 		default:
 			formData.Set(name, value)
 		}
 	}
 
-	saml.LoginPage.ActionUrl = saml.IdpEntryUrl + scrape.Attr(form, "action")
+	// The action is usually relative to the login portal but may be absolute
+	entryUrl, err := url.Parse(saml.IdpEntryUrl)
+	if err != nil {
+		return fmt.Errorf("parsing the IdP entry URL: %w", err)
+	}
+	action, err := url.Parse(strings.TrimSpace(scrape.Attr(form, "action")))
+	if err != nil {
+		return fmt.Errorf("parsing the login form action: %w", err)
+	}
+	saml.LoginPage.ActionUrl = entryUrl.ResolveReference(action).String()
 	saml.LoginPage.FormData = formData
 	log.Info("Portal login complete!")
+	return nil
 }
 
 // Configures retrieval of the SAML response from the login portal and returns this
-// response back to the parent SAML struct.
-func (saml *Saml) assertion() {
+// response back to the parent SAML struct. A response without an assertion means the
+// portal rejected the login.
+func (saml *Saml) assertion(ctx context.Context) error {
 	log := saml.Logger
 	log.Info("Starting SAML assertion parsing...")
-	client := saml.newHttpClient()
-	page, err := client.Post(saml.LoginPage.ActionUrl, "application/x-www-form-urlencoded", strings.NewReader(saml.LoginPage.FormData.Encode()))
-	utils.Check(err, "Error with POST for SAML assertion")
+	client, err := saml.newHttpClient()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, saml.LoginPage.ActionUrl, strings.NewReader(saml.LoginPage.FormData.Encode()))
+	if err != nil {
+		return fmt.Errorf("posting the login form: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	page, err := client.Do(request)
+	if err != nil {
+		return types.NewError(types.ErrNetwork, "posting the login form", err)
+	}
 	defer page.Body.Close()
 	root, err := html.Parse(page.Body)
-	utils.Check(err, "Error parsing SAML HTML response")
+	if err != nil {
+		return fmt.Errorf("parsing SAML HTML response: %w", err)
+	}
 	input, ok := scrape.Find(root, func(hn *html.Node) bool {
 		return hn.DataAtom == atom.Input && scrape.Attr(hn, "name") == "SAMLResponse"
 	})
 	if saml.Assertion == "" && ok {
 		saml.Assertion = scrape.Attr(input, "value")
 	}
+	if saml.Assertion == "" {
+		return types.NewError(types.ErrAuthFailed, "retrieving SAML assertion: the login portal returned no assertion, check your username and password", nil)
+	}
+	logging.AddSecret(log, saml.Assertion)
 	log.Info("SAML Assertion complete!")
+	return nil
 }
 
 // Parses the SAML assertion to retrieve the list of AWS IAM roles that the
 // authenticated user has access to assume. These roles are written back to
-// a global types variable to be accessible for user selection prompts.
-func (saml *Saml) parseSamlRoles() {
+// the Roles field to be accessible for user selection prompts, while
+// the remaining AWS attributes are collected into the Attributes field.
+func (saml *Saml) parseSamlAttributes() error {
 	log := saml.Logger
-	log.Info("Begin parsing AWS roles from SAML response...")
+	log.Info("Begin parsing AWS attributes from SAML response...")
 	err := xml.Unmarshal(saml.DecodedSaml, &saml.SamlXMLResponse)
-	utils.Check(err, "Error unmarshalling SAML XML response")
+	if err != nil {
+		return fmt.Errorf("unmarshalling SAML XML response: %w", err)
+	}
+	saml.Attributes = AwsAttributes{PrincipalTags: map[string]string{}}
+	saml.Roles = nil
 	for _, attrs := range saml.SamlXMLResponse.Attrs {
-		if attrs.Name == "https://aws.amazon.com/SAML/Attributes/Role" {
+		switch {
+		case attrs.Name == roleAttribute:
 			for _, val := range attrs.Values {
-				roleStr := strings.Split(val, ",")
-				role := types.Role{
-					Name:         roleStr[1],
-					PrincipalArn: roleStr[0],
+				role, err := parseRoleAttribute(val)
+				if err != nil {
+					log.Warnf("Skipping malformed role attribute -- %v", err)
+					continue
+				}
+				if !containsRole(saml.Roles, role) {
+					saml.Roles = append(saml.Roles, role)
 				}
-				types.Roles = append(types.Roles, role)
 			}
+		case attrs.Name == sessionDurationAttribute && len(attrs.Values) > 0:
+			duration, err := strconv.Atoi(strings.TrimSpace(attrs.Values[0]))
+			if err != nil {
+				log.Warnf("Ignoring invalid SessionDuration attribute %q", attrs.Values[0])
+				continue
+			}
+			saml.Attributes.SessionDuration = duration
+		case attrs.Name == roleSessionNameAttribute && len(attrs.Values) > 0:
+			saml.Attributes.RoleSessionName = strings.TrimSpace(attrs.Values[0])
+		case attrs.Name == sourceIdentityAttribute && len(attrs.Values) > 0:
+			saml.Attributes.SourceIdentity = strings.TrimSpace(attrs.Values[0])
+		case attrs.Name == transitiveTagKeysAttribute:
+			for _, val := range attrs.Values {
+				saml.Attributes.TransitiveTagKeys = append(saml.Attributes.TransitiveTagKeys, strings.TrimSpace(val))
+			}
+		case strings.HasPrefix(attrs.Name, principalTagAttribute) && len(attrs.Values) > 0:
+			tag := strings.TrimPrefix(attrs.Name, principalTagAttribute)
+			saml.Attributes.PrincipalTags[tag] = strings.TrimSpace(attrs.Values[0])
 		}
 	}
-	log.Infof("Parsed Access Roles: %v", types.Roles)
-	log.Info("Role parsing complete!")
+	log.Infof("Parsed Access Roles: %v", saml.Roles)
+	log.Debugf("Parsed AWS Attributes: %+v", saml.Attributes)
+	if len(saml.Roles) == 0 {
+		return types.NewError(types.ErrNoRoles, "parsing AWS roles: the SAML assertion grants no AWS roles", nil)
+	}
+	log.Info("Attribute parsing complete!")
+	return nil
 }
 
 // General purpose http Client configuration if users provide a
 // CA bundle path.
-func (saml *Saml) newHttpClient() *http.Client {
-	client := &http.Client{}
-	if saml.CABundle != "" {
-		caCert, err := ioutil.ReadFile(saml.CABundle)
-		utils.Check(err, "Error reading CA Bundle")
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-		client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					RootCAs: caCertPool,
-				},
+func (saml *Saml) newHttpClient() (*http.Client, error) {
+	return newHttpClient(saml.CABundle)
+}
+
+func newHttpClient(caBundle string) (*http.Client, error) {
+	if caBundle == "" {
+		return &http.Client{}, nil
+	}
+	return bundleHttpClient(caBundle, x509.NewCertPool())
+}
+
+// The AWS sign-in page is served with a public certificate, so the CA bundle,
+// often a corporate proxy bundle, is trusted alongside the system roots rather
+// than in place of them.
+func newSigninHttpClient(caBundle string) (*http.Client, error) {
+	if caBundle == "" {
+		return &http.Client{}, nil
+	}
+	caCertPool, err := x509.SystemCertPool()
+	if err != nil {
+		caCertPool = x509.NewCertPool()
+	}
+	return bundleHttpClient(caBundle, caCertPool)
+}
+
+func bundleHttpClient(caBundle string, caCertPool *x509.CertPool) (*http.Client, error) {
+	caCert, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("reading the CA bundle: %w", err)
+	}
+	caCertPool.AppendCertsFromPEM(caCert)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: caCertPool,
 			},
-		}
+		},
 	}
-	return client
+	return client, nil
 }
@@ -1,12 +1,16 @@
 package saml
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/S7R4nG3/aws-adfs-login/types"
@@ -15,8 +19,12 @@ import (
 
 const (
 	testLoginPage    = "../tests/login-page.html"
+	testModernPage   = "../tests/login-page-modern.html"
+	testCustomPage   = "../tests/login-page-custom.html"
+	testAbsolutePage = "../tests/login-page-absolute.html"
 	testLoginSuccess = "../tests/login-success.html"
 	testSamlResponse = "../tests/saml-response.xml"
+	testSamlAttrs    = "../tests/saml-response-attributes.xml"
 )
 
 func Test_Verify(t *testing.T) {
@@ -32,11 +40,38 @@ func Test_Verify(t *testing.T) {
 	})
 	server := httptest.NewServer(mux)
 	defer server.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		testLoginBody, _ := os.ReadFile(testLoginPage)
+		rw.Write(testLoginBody)
+	}))
+	defer rejecting.Close()
+	noRoles := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			testLoginBody, _ := os.ReadFile(testLoginPage)
+			rw.Write(testLoginBody)
+			return
+		}
+		response := base64.StdEncoding.EncodeToString([]byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"><Assertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion"><AttributeStatement/></Assertion></samlp:Response>`))
+		rw.Write([]byte(`<html><body><form method="post"><input type="hidden" name="SAMLResponse" value="` + response + `" /></form></body></html>`))
+	}))
+	defer noRoles.Close()
+	malformed := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			testLoginBody, _ := os.ReadFile(testLoginPage)
+			rw.Write(testLoginBody)
+			return
+		}
+		rw.Write([]byte(`<html><body><form method="post"><input type="hidden" name="SAMLResponse" value="not base64!" /></form></body></html>`))
+	}))
+	defer malformed.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
 
 	tests := []struct {
-		name  string
-		input Saml
-		want  []types.Role
+		name    string
+		input   Saml
+		want    []types.Role
+		wantErr error
 	}{
 		{
 			name: "Test Saml Verify",
@@ -55,31 +90,115 @@ func Test_Verify(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Test unreachable login portal",
+			input: Saml{
+				IdpEntryUrl: closed.URL,
+				Logger:      logrus.New(),
+			},
+			wantErr: types.ErrNetwork,
+		},
+		{
+			name: "Test rejected login",
+			input: Saml{
+				IdpEntryUrl: rejecting.URL,
+				Logger:      logrus.New(),
+			},
+			wantErr: types.ErrAuthFailed,
+		},
+		{
+			name: "Test assertion without roles",
+			input: Saml{
+				IdpEntryUrl: noRoles.URL,
+				Logger:      logrus.New(),
+			},
+			wantErr: types.ErrNoRoles,
+		},
+		{
+			name: "Test assertion that isn't base64 encoded",
+			input: Saml{
+				IdpEntryUrl: malformed.URL,
+				Logger:      logrus.New(),
+			},
+			wantErr: types.ErrAuthFailed,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Logf("Running test -- %s", tt.name)
-		tt.input.Verify()
-		if !reflect.DeepEqual(types.Roles, tt.want) {
-			t.Errorf("Error running test -- got: %v want: %v", types.Roles, tt.want)
+		err := tt.input.Verify(context.Background())
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Error running test -- got: %v want: %v", err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(tt.input.Roles, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", tt.input.Roles, tt.want)
 		}
 	}
 }
 
-func Test_ADFS_Portal_Login(t *testing.T) {
+func Test_Verify_Concurrent(t *testing.T) {
+	var mu sync.Mutex
+	submitted := map[string]bool{}
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		testBody, _ := os.ReadFile(testLoginPage)
-		rw.Write(testBody)
+		if req.URL.Path == "/" {
+			testLoginBody, _ := os.ReadFile(testLoginPage)
+			rw.Write(testLoginBody)
+			return
+		}
+		req.ParseForm()
+		mu.Lock()
+		submitted[req.PostForm.Get("ctl00$ContentPlaceHolder1$UsernameTextBox")] = true
+		mu.Unlock()
+		testLoginSuccess, _ := os.ReadFile(testLoginSuccess)
+		rw.Write(testLoginSuccess)
 	}))
 	defer server.Close()
 
+	logins := make([]Saml, 8)
+	errs := make([]error, len(logins))
+	var wg sync.WaitGroup
+	for i := range logins {
+		logins[i] = Saml{
+			IdpEntryUrl:    server.URL,
+			UsernameFormat: UsernameFormatPlain,
+			User:           types.User{Username: fmt.Sprintf("user%d", i), Password: "cheese"},
+			Logger:         logrus.New(),
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = logins[i].Verify(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for i, login := range logins {
+		t.Logf("Running test -- concurrent login %d", i)
+		if errs[i] != nil {
+			t.Errorf("Error running test -- got: %v want: %v", errs[i], nil)
+		}
+		if len(login.Roles) != 2 {
+			t.Errorf("Error running test -- got: %v want: %v", len(login.Roles), 2)
+		}
+		if !submitted[login.User.Username] {
+			t.Errorf("Error running test -- got: %v want: %v", submitted, login.User.Username)
+		}
+	}
+}
+
+func Test_ADFS_Portal_Login(t *testing.T) {
 	tests := []struct {
-		name string
-		user types.User
-		want url.Values
+		name       string
+		page       string
+		entryPath  string
+		user       types.User
+		input      Saml
+		want       url.Values
+		wantAction string
 	}{
 		{
 			name: "Validate the login form data parsing is correct",
+			page: testLoginPage,
 			user: types.User{
 				Username: "potato",
 				Password: "cheese",
@@ -95,21 +214,115 @@ func Test_ADFS_Portal_Login(t *testing.T) {
 				"ctl00$ContentPlaceHolder1$SubmitButton":    []string{"Sign In"},
 			},
 		},
+		{
+			name: "Validate fields are discovered by input type within the form holding the password",
+			page: testModernPage,
+			user: types.User{
+				Username: "potato",
+				Password: "cheese",
+				Domain:   "domain",
+			},
+			want: url.Values{
+				"AuthMethod": []string{"FormsAuthentication"},
+				"UserName":   []string{"domain\\potato"},
+				"Secret":     []string{"cheese"},
+			},
+		},
+		{
+			name: "Validate the username is submitted in the configured format",
+			page: testModernPage,
+			user: types.User{
+				Username: "potato",
+				Password: "cheese",
+				Domain:   "domain.example",
+			},
+			input: Saml{
+				UsernameFormat: UsernameFormatUpn,
+			},
+			want: url.Values{
+				"AuthMethod": []string{"FormsAuthentication"},
+				"UserName":   []string{"potato@domain.example"},
+				"Secret":     []string{"cheese"},
+			},
+		},
+		{
+			name: "Validate the form selector and field overrides are honored",
+			page: testCustomPage,
+			user: types.User{
+				Username: "potato",
+				Password: "cheese",
+				Domain:   "domain",
+			},
+			input: Saml{
+				UsernameField: "acct",
+				PasswordField: "pin",
+				FormSelector:  "#corpLogin",
+			},
+			want: url.Values{
+				"Context":      []string{"somecontext"},
+				"PasswordHint": []string{""},
+				"acct":         []string{"domain\\potato"},
+				"pin":          []string{"cheese"},
+				"Go":           []string{"Sign In"},
+			},
+		},
+		{
+			name:      "Validate a root-relative action is resolved against the IdP entry URL",
+			page:      testModernPage,
+			entryPath: "/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices",
+			user: types.User{
+				Username: "potato",
+				Password: "cheese",
+				Domain:   "domain",
+			},
+			want: url.Values{
+				"AuthMethod": []string{"FormsAuthentication"},
+				"UserName":   []string{"domain\\potato"},
+				"Secret":     []string{"cheese"},
+			},
+		},
+		{
+			name:      "Validate an absolute action is used as is",
+			page:      testAbsolutePage,
+			entryPath: "/adfs/ls/IdpInitiatedSignOn.aspx?loginToRp=urn:amazon:webservices",
+			user: types.User{
+				Username: "potato",
+				Password: "cheese",
+				Domain:   "domain",
+			},
+			want: url.Values{
+				"AuthMethod": []string{"FormsAuthentication"},
+				"UserName":   []string{"domain\\potato"},
+				"Secret":     []string{"cheese"},
+			},
+			wantAction: "https://sts.example.com/adfs/ls/?SAMLRequest=REQUEST",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Logf("Running test -- %s", tt.name)
-		types.LoginUser = tt.user
-		mfa := Saml{
-			IdpEntryUrl: server.URL,
-			Logger:      logrus.New(),
-		}
-		mfa.portalLogin()
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			testBody, _ := os.ReadFile(tt.page)
+			rw.Write(testBody)
+		}))
+		mfa := tt.input
+		mfa.User = tt.user
+		mfa.IdpEntryUrl = server.URL + tt.entryPath
+		mfa.Logger = logrus.New()
+		mfa.portalLogin(context.Background())
+		server.Close()
 		got := mfa.LoginPage.FormData
 		t.Logf("Login Form Data: %v", got)
 		if !reflect.DeepEqual(got, tt.want) {
 			t.Errorf("Error running test -- got: %v want: %v", got, tt.want)
 		}
+		wantAction := tt.wantAction
+		if wantAction == "" {
+			wantAction = server.URL + "/adfs/ls/?SAMLRequest=REQUEST"
+		}
+		if mfa.LoginPage.ActionUrl != wantAction {
+			t.Errorf("Error running test -- got action: %v want: %v", mfa.LoginPage.ActionUrl, wantAction)
+		}
 	}
 }
 
@@ -150,7 +363,7 @@ func Test_SAML_Assertion(t *testing.T) {
 			},
 			Logger: logrus.New(),
 		}
-		mfa.assertion()
+		mfa.assertion(context.Background())
 		got := mfa.Assertion
 		t.Logf("Saml Assertion: %s", got)
 		if got != tt.want {
@@ -183,15 +396,83 @@ func Test_Saml_Role_Parsing(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Logf("Running test -- %s", tt.name)
-		types.Roles = []types.Role{}
 		saml := Saml{
 			Assertion: tt.input,
 			Logger:    logrus.New(),
 		}
 		saml.DecodedSaml, _ = base64.StdEncoding.DecodeString(saml.Assertion)
-		saml.parseSamlRoles()
-		if !reflect.DeepEqual(types.Roles, tt.want) {
-			t.Errorf("Error running test -- got: %v want: %v", types.Roles, tt.want)
+		saml.parseSamlAttributes()
+		if !reflect.DeepEqual(saml.Roles, tt.want) {
+			t.Errorf("Error running test -- got: %v want: %v", saml.Roles, tt.want)
+		}
+	}
+}
+
+func Test_Saml_Attribute_Parsing(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      AwsAttributes
+		wantRoles []types.Role
+	}{
+		{
+			name:  "Validate the default AWS attributes are parsed",
+			input: testSamlResponse,
+			want: AwsAttributes{
+				RoleSessionName: "JohnStamos",
+				SessionDuration: 3600,
+				PrincipalTags:   map[string]string{},
+			},
+			wantRoles: []types.Role{
+				{
+					Name:         "arn:aws:iam::123456789123:role/AdministratorAccess",
+					PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+				},
+				{
+					Name:         "arn:aws:iam::987654321321:role/DeveloperAccess",
+					PrincipalArn: "arn:aws:iam::987654321321:saml-provider/ADFS",
+				},
+			},
+		},
+		{
+			name:  "Validate session tags and source identity are parsed",
+			input: testSamlAttrs,
+			want: AwsAttributes{
+				RoleSessionName: "JohnStamos",
+				SessionDuration: 28800,
+				SourceIdentity:  "john.stamos@example.com",
+				PrincipalTags: map[string]string{
+					"Department": "Engineering",
+					"CostCenter": "12345",
+				},
+				TransitiveTagKeys: []string{"Department", "CostCenter"},
+			},
+			wantRoles: []types.Role{
+				{
+					Name:         "arn:aws:iam::123456789123:role/AdministratorAccess",
+					PrincipalArn: "arn:aws:iam::123456789123:saml-provider/ADFS",
+				},
+				{
+					Name:         "arn:aws:iam::987654321321:role/DeveloperAccess",
+					PrincipalArn: "arn:aws:iam::987654321321:saml-provider/ADFS",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		decoded, _ := os.ReadFile(tt.input)
+		saml := Saml{
+			DecodedSaml: decoded,
+			Logger:      logrus.New(),
+		}
+		saml.parseSamlAttributes()
+		if !reflect.DeepEqual(saml.Attributes, tt.want) {
+			t.Errorf("Error running test -- got: %+v want: %+v", saml.Attributes, tt.want)
+		}
+		if !reflect.DeepEqual(saml.Roles, tt.wantRoles) {
+			t.Errorf("Error running test -- got roles: %v want: %v", saml.Roles, tt.wantRoles)
 		}
 	}
 }
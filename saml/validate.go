@@ -0,0 +1,223 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	awsAudience = "urn:amazon:webservices"
+	clockSkew   = 2 * time.Minute
+)
+
+// Overridable clock so the assertion time window can be tested.
+var now = time.Now
+
+// The subset of the SAML response used to validate where and when an assertion
+// may be used.
+type SamlConditions struct {
+	Destination string        `xml:"Destination,attr"`
+	Conditions  XmlConditions `xml:"Assertion>Conditions"`
+}
+
+type XmlConditions struct {
+	NotBefore    string   `xml:"NotBefore,attr"`
+	NotOnOrAfter string   `xml:"NotOnOrAfter,attr"`
+	Audiences    []string `xml:"AudienceRestriction>Audience"`
+}
+
+// Validates the decoded SAML response before any roles are trusted. The XML signature
+// is verified against the IdP token-signing certificates, then the assertion time window,
+// audience and destination are checked. The verified response is returned, holding only
+// the signed assertion, so the roles and attributes are never read from unsigned content.
+func (saml *Saml) validateAssertion(ctx context.Context) ([]byte, error) {
+	log := saml.Logger
+	log.Info("Begin SAML assertion validation...")
+	certs, err := saml.signingCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	verified, err := verifySignature(saml.DecodedSaml, certs)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("SAML signature verified")
+
+	doc := etree.NewDocument()
+	doc.SetRoot(verified)
+	content, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("serializing the verified SAML response: %w", err)
+	}
+	var conditions SamlConditions
+	if err := xml.Unmarshal(content, &conditions); err != nil {
+		return nil, fmt.Errorf("parsing SAML conditions: %w", err)
+	}
+	if err := conditions.validate(now()); err != nil {
+		return nil, err
+	}
+	log.Info("SAML assertion validation complete!")
+	return content, nil
+}
+
+// Verifies the enveloped signature of the response. Exactly one assertion must be
+// present and either it or the enclosing response must carry a valid signature. The
+// returned response is built from the validated element only, so anything wrapped
+// around or next to the signed content is dropped.
+func verifySignature(decoded []byte, certs []*x509.Certificate) (*etree.Element, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no IdP signing certificates available to verify the SAML signature")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(decoded); err != nil {
+		return nil, fmt.Errorf("parsing SAML response: %w", err)
+	}
+	response := doc.Root()
+	if response == nil || response.Tag != "Response" {
+		return nil, errors.New("SAML response root element is not a Response")
+	}
+	assertions := doc.FindElements("//Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("expected exactly one SAML assertion, found %d", len(assertions))
+	}
+
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certs})
+	if response.SelectElement("Signature") != nil {
+		verified, err := ctx.Validate(response)
+		if err != nil {
+			return nil, fmt.Errorf("SAML signature verification failed: %w", err)
+		}
+		return verified, nil
+	}
+	verified, err := ctx.Validate(assertions[0])
+	if err != nil {
+		return nil, fmt.Errorf("SAML signature verification failed: %w", err)
+	}
+	// Only the assertion is signed, keep the response attributes such as the
+	// Destination around it
+	wrapped := etree.NewElement(response.Tag)
+	wrapped.Space = response.Space
+	wrapped.Attr = append(wrapped.Attr, response.Attr...)
+	wrapped.AddChild(verified)
+	return wrapped, nil
+}
+
+// Checks the assertion is currently valid, intended for AWS, and was addressed to
+// an AWS sign-in endpoint.
+func (response SamlConditions) validate(t time.Time) error {
+	c := response.Conditions
+	if c.NotBefore != "" {
+		notBefore, err := time.Parse(time.RFC3339, c.NotBefore)
+		if err != nil {
+			return fmt.Errorf("invalid SAML NotBefore %q: %w", c.NotBefore, err)
+		}
+		if t.Add(clockSkew).Before(notBefore) {
+			return fmt.Errorf("SAML assertion is not valid before %s", c.NotBefore)
+		}
+	}
+	if c.NotOnOrAfter == "" {
+		return errors.New("SAML assertion has no NotOnOrAfter condition")
+	}
+	notOnOrAfter, err := time.Parse(time.RFC3339, c.NotOnOrAfter)
+	if err != nil {
+		return fmt.Errorf("invalid SAML NotOnOrAfter %q: %w", c.NotOnOrAfter, err)
+	}
+	if !t.Add(-clockSkew).Before(notOnOrAfter) {
+		return fmt.Errorf("SAML assertion expired at %s", c.NotOnOrAfter)
+	}
+
+	audienceOk := false
+	for _, audience := range c.Audiences {
+		if strings.TrimSpace(audience) == awsAudience {
+			audienceOk = true
+		}
+	}
+	if !audienceOk {
+		return fmt.Errorf("SAML assertion audience %v does not include %s", c.Audiences, awsAudience)
+	}
+
+	if response.Destination == "" {
+		return errors.New("SAML response has no Destination")
+	}
+	if !isAwsSigninEndpoint(response.Destination) {
+		return fmt.Errorf("SAML response destination %s is not an AWS sign-in endpoint", response.Destination)
+	}
+	return nil
+}
+
+// Accepts the global and regional AWS SAML sign-in endpoints across partitions.
+func isAwsSigninEndpoint(destination string) bool {
+	u, err := url.Parse(destination)
+	if err != nil || u.Scheme != "https" || u.Path != "/saml" {
+		return false
+	}
+	for _, host := range []string{"signin.aws.amazon.com", "signin.amazonaws-us-gov.com", "signin.amazonaws.cn"} {
+		if u.Host == host || strings.HasSuffix(u.Host, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads the IdP token-signing certificates from a PEM file and/or a FederationMetadata.xml
+// document given as a local path or https URL. One of them is required, as metadata
+// discovered from the IDP entry URL would be trusted from the server being verified.
+func (saml *Saml) signingCertificates(ctx context.Context) ([]*x509.Certificate, error) {
+	if saml.IdpCertificate == "" && saml.IdpMetadata == "" {
+		return nil, errors.New("validating the SAML assertion requires an IdP certificate or federation metadata location")
+	}
+	var certs []*x509.Certificate
+	if saml.IdpCertificate != "" {
+		pemCerts, err := parsePemCertificates(saml.IdpCertificate)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, pemCerts...)
+	}
+	if saml.IdpMetadata != "" {
+		metadata, err := NewMetadataCache(saml.CABundle, saml.Logger).Load(ctx, saml.IdpMetadata)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, metadata.SigningCertificates...)
+	}
+	return certs, nil
+}
+
+func parsePemCertificates(path string) ([]*x509.Certificate, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading IdP certificate: %w", err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing IdP certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return certs, nil
+}
@@ -0,0 +1,267 @@
+package saml
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sirupsen/logrus"
+)
+
+type testIdp struct {
+	signer *dsig.SigningContext
+	cert   *x509.Certificate
+}
+
+func newTestIdp(t *testing.T) testIdp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating test key -- %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ADFS Signing - adfs.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error generating test certificate -- %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	signer, err := dsig.NewSigningContext(key, [][]byte{der})
+	if err != nil {
+		t.Fatalf("Error creating signing context -- %v", err)
+	}
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return testIdp{signer: signer, cert: cert}
+}
+
+type testAssertion struct {
+	destination  string
+	notBefore    time.Time
+	notOnOrAfter time.Time
+	audience     string
+	role         string
+}
+
+func (a testAssertion) element(id string) *etree.Element {
+	assertion := etree.NewElement("Assertion")
+	assertion.CreateAttr("xmlns", "urn:oasis:names:tc:SAML:2.0:assertion")
+	assertion.CreateAttr("ID", id)
+	assertion.CreateAttr("Version", "2.0")
+	assertion.CreateElement("Issuer").SetText("http://adfs.example/adfs/services/trust")
+	conditions := assertion.CreateElement("Conditions")
+	conditions.CreateAttr("NotBefore", a.notBefore.UTC().Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", a.notOnOrAfter.UTC().Format(time.RFC3339))
+	conditions.CreateElement("AudienceRestriction").CreateElement("Audience").SetText(a.audience)
+	attr := assertion.CreateElement("AttributeStatement").CreateElement("Attribute")
+	attr.CreateAttr("Name", "https://aws.amazon.com/SAML/Attributes/Role")
+	attr.CreateElement("AttributeValue").SetText(a.role)
+	return assertion
+}
+
+// Builds a SAML response whose assertion is signed by the test IdP, then applies the
+// optional tamper function to the serialized document.
+func (idp testIdp) response(t *testing.T, a testAssertion, tamper func(*etree.Document)) []byte {
+	signed, err := idp.signer.SignEnveloped(a.element("_assertion"))
+	if err != nil {
+		t.Fatalf("Error signing test assertion -- %v", err)
+	}
+	doc := etree.NewDocument()
+	response := doc.CreateElement("samlp:Response")
+	response.CreateAttr("xmlns:samlp", "urn:oasis:names:tc:SAML:2.0:protocol")
+	response.CreateAttr("ID", "_response")
+	response.CreateAttr("Version", "2.0")
+	response.CreateAttr("Destination", a.destination)
+	response.AddChild(signed)
+	if tamper != nil {
+		tamper(doc)
+	}
+	out, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("Error serializing test response -- %v", err)
+	}
+	return out
+}
+
+func Test_Assertion_Validation(t *testing.T) {
+	fixedNow := time.Date(2022, 9, 12, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixedNow }
+	defer func() { now = time.Now }()
+
+	idp := newTestIdp(t)
+	otherIdp := newTestIdp(t)
+	valid := testAssertion{
+		destination:  "https://signin.aws.amazon.com/saml",
+		notBefore:    fixedNow.Add(-time.Minute),
+		notOnOrAfter: fixedNow.Add(time.Hour),
+		audience:     "urn:amazon:webservices",
+		role:         "arn:aws:iam::123456789123:saml-provider/ADFS,arn:aws:iam::123456789123:role/AdministratorAccess",
+	}
+
+	tests := []struct {
+		name      string
+		assertion testAssertion
+		certs     []*x509.Certificate
+		tamper    func(*etree.Document)
+		wantErr   string
+	}{
+		{
+			name:      "Validate a correctly signed assertion is accepted",
+			assertion: valid,
+			certs:     []*x509.Certificate{idp.cert},
+		},
+		{
+			name: "Validate regional sign-in destinations are accepted",
+			assertion: func() testAssertion {
+				a := valid
+				a.destination = "https://us-east-1.signin.aws.amazon.com/saml"
+				return a
+			}(),
+			certs: []*x509.Certificate{idp.cert},
+		},
+		{
+			name:      "Validate assertions signed by another certificate are rejected",
+			assertion: valid,
+			certs:     []*x509.Certificate{otherIdp.cert},
+			wantErr:   "signature verification failed",
+		},
+		{
+			name:      "Validate tampered role lists are rejected",
+			assertion: valid,
+			certs:     []*x509.Certificate{idp.cert},
+			tamper: func(doc *etree.Document) {
+				value := doc.FindElement("//AttributeValue")
+				value.SetText("arn:aws:iam::666666666666:saml-provider/ADFS,arn:aws:iam::666666666666:role/Evil")
+			},
+			wantErr: "signature verification failed",
+		},
+		{
+			name:      "Validate injected unsigned assertions are rejected",
+			assertion: valid,
+			certs:     []*x509.Certificate{idp.cert},
+			tamper: func(doc *etree.Document) {
+				doc.Root().AddChild(valid.element("_injected"))
+			},
+			wantErr: "exactly one SAML assertion",
+		},
+		{
+			name: "Validate expired assertions are rejected",
+			assertion: func() testAssertion {
+				a := valid
+				a.notBefore = fixedNow.Add(-2 * time.Hour)
+				a.notOnOrAfter = fixedNow.Add(-time.Hour)
+				return a
+			}(),
+			certs:   []*x509.Certificate{idp.cert},
+			wantErr: "expired",
+		},
+		{
+			name: "Validate assertions issued for the future are rejected",
+			assertion: func() testAssertion {
+				a := valid
+				a.notBefore = fixedNow.Add(time.Hour)
+				a.notOnOrAfter = fixedNow.Add(2 * time.Hour)
+				return a
+			}(),
+			certs:   []*x509.Certificate{idp.cert},
+			wantErr: "not valid before",
+		},
+		{
+			name: "Validate assertions for other audiences are rejected",
+			assertion: func() testAssertion {
+				a := valid
+				a.audience = "urn:example:someotherapp"
+				return a
+			}(),
+			certs:   []*x509.Certificate{idp.cert},
+			wantErr: "audience",
+		},
+		{
+			name: "Validate non AWS destinations are rejected",
+			assertion: func() testAssertion {
+				a := valid
+				a.destination = "https://signin.aws.amazon.com.evil.example/saml"
+				return a
+			}(),
+			certs:   []*x509.Certificate{idp.cert},
+			wantErr: "destination",
+		},
+		{
+			name: "Validate responses without a destination are rejected",
+			assertion: func() testAssertion {
+				a := valid
+				a.destination = ""
+				return a
+			}(),
+			certs:   []*x509.Certificate{idp.cert},
+			wantErr: "no Destination",
+		},
+		{
+			name:      "Validate unsigned content next to the signed assertion is dropped",
+			assertion: valid,
+			certs:     []*x509.Certificate{idp.cert},
+			tamper: func(doc *etree.Document) {
+				attr := doc.Root().CreateElement("AttributeStatement").CreateElement("Attribute")
+				attr.CreateAttr("Name", "https://aws.amazon.com/SAML/Attributes/Role")
+				attr.CreateElement("AttributeValue").SetText("arn:aws:iam::666666666666:saml-provider/ADFS,arn:aws:iam::666666666666:role/Evil")
+			},
+		},
+		{
+			name:      "Validate a missing signing certificate source is an error",
+			assertion: valid,
+			wantErr:   "requires an IdP certificate or federation metadata location",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("Running test -- %s", tt.name)
+		saml := Saml{
+			DecodedSaml: idp.response(t, tt.assertion, tt.tamper),
+			Logger:      logrus.New(),
+		}
+		if len(tt.certs) > 0 {
+			saml.IdpCertificate = writePemCertificates(t, tt.certs)
+		}
+		verified, err := saml.validateAssertion(context.Background())
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Error running test -- unexpected error: %v", err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Error running test -- got: %v want error containing: %s", err, tt.wantErr)
+		case err == nil:
+			var response SamlXMLResponse
+			xml.Unmarshal(verified, &response)
+			if len(response.Attrs) != 1 || !reflect.DeepEqual(response.Attrs[0].Values, []string{tt.assertion.role}) || strings.Contains(string(verified), "666666666666") {
+				t.Errorf("Error running test -- got: %v want: only the signed role %s", response.Attrs, tt.assertion.role)
+			}
+		}
+	}
+}
+
+func writePemCertificates(t *testing.T, certs []*x509.Certificate) string {
+	path := filepath.Join(t.TempDir(), "idp.pem")
+	var content []byte
+	for _, cert := range certs {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("Error writing test certificate -- %v", err)
+	}
+	return path
+}
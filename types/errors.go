@@ -0,0 +1,46 @@
+package types
+
+import "errors"
+
+// The kinds of login failure, matched with errors.Is and mapped to the process
+// exit codes of the CLI.
+var (
+	ErrNetwork     = errors.New("network error")
+	ErrAuthFailed  = errors.New("authentication failed")
+	ErrNoRoles     = errors.New("no AWS roles available")
+	ErrStsDenied   = errors.New("STS denied the request")
+	ErrWriteFailed = errors.New("writing the credentials failed")
+)
+
+// A login failure of one of the kinds above, wrapping the error that caused it.
+type LoginError struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+// Creates a login error of the given kind, err may be nil. An empty msg keeps the
+// message of err.
+func NewError(kind error, msg string, err error) error {
+	return &LoginError{Kind: kind, Msg: msg, Err: err}
+}
+
+func (e *LoginError) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+	if e.Msg == "" {
+		return e.Err.Error()
+	}
+	return e.Msg + ": " + e.Err.Error()
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+// Matches the kind of the error, so errors.Is(err, ErrNetwork) holds for
+// network failures.
+func (e *LoginError) Is(target error) bool {
+	return target == e.Kind
+}
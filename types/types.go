@@ -1,5 +1,7 @@
 package types
 
+import "strings"
+
 const (
 	Header = `
  █████  ██     ██ ███████      █████  ██████  ███████ ███████     ██       ██████   ██████  ██ ███    ██ 
@@ -9,16 +11,6 @@ const (
 ██   ██  ███ ███  ███████     ██   ██ ██████  ██      ███████     ███████  ██████   ██████  ██ ██   ████`
 )
 
-// A global User variable used to contain the user login credentials
-// so they can be provided via CLI flags or via direct user prompts.
-var LoginUser User
-
-// A global Role slice that contains a list of all available AWS IAM roles
-// that an authenticated user has the ability to assume. This is used to
-// be easily passed into a user selection prompt to select a particular
-// role to login.
-var Roles []Role
-
 // A generic User struct used to contain user login credentials
 type User struct {
 	Username string
@@ -26,8 +18,37 @@ type User struct {
 	Domain   string
 }
 
-// A generic Role struct used to contain an AWS IAM role and its principal ARN.
+// A generic Role struct used to contain an AWS IAM role and its principal ARN,
+// along with the friendly account alias once it has been resolved.
 type Role struct {
 	Name         string
 	PrincipalArn string
+	AccountAlias string
+}
+
+// Returns the 12 digit AWS account ID from the role ARN.
+func (role Role) AccountId() string {
+	fields := strings.Split(role.Name, ":")
+	if len(fields) < 5 {
+		return ""
+	}
+	return fields[4]
+}
+
+// Returns the role name without the ARN prefix or path.
+func (role Role) RoleName() string {
+	return role.Name[strings.LastIndex(role.Name, "/")+1:]
+}
+
+// A human friendly label for the account, preferring the alias over its ID.
+func (role Role) AccountLabel() string {
+	if role.AccountAlias != "" {
+		return role.AccountAlias + " (" + role.AccountId() + ")"
+	}
+	return role.AccountId()
+}
+
+// A human friendly label for the role within its account.
+func (role Role) Label() string {
+	return role.AccountLabel() + " - " + role.RoleName()
 }